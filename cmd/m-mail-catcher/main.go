@@ -0,0 +1,102 @@
+/*
+	Command m-mail-catcher is an SMTP server for local development. It accepts
+	every message, keeps it in memory or on disk, and shows it in a web UI.
+
+	Usage:
+
+		m-mail-catcher [-smtp :1025] [-http :1080] [-dir path]
+
+	The messages can also be read with the JSON API:
+
+		GET    /api/messages              the list of the messages
+		GET    /api/messages/{id}         a message with its parts
+		GET    /api/messages/{id}/raw     the source of a message
+		DELETE /api/messages              delete all the messages
+*/
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ishail/m-mail/server"
+)
+
+func main() {
+	smtpAddr := flag.String("smtp", "127.0.0.1:1025", "address of the SMTP server")
+	httpAddr := flag.String("http", "127.0.0.1:1080", "address of the web UI")
+	dir := flag.String("dir", "", "directory where the messages are saved, in memory if empty")
+	flag.Parse()
+
+	s, err := openStore(*dir)
+	if err != nil {
+		log.Fatalf("m-mail-catcher: unable to open the store: %v", err)
+	}
+
+	srv := server.NewServer(&backend{store: s})
+	srv.Addr = *smtpAddr
+	srv.Domain = "m-mail-catcher"
+	srv.AllowInsecureAuth = true
+
+	go func() {
+		log.Printf("m-mail-catcher: SMTP server listening on %s", *smtpAddr)
+		log.Fatal(srv.ListenAndServe())
+	}()
+
+	log.Printf("m-mail-catcher: web UI listening on http://%s", *httpAddr)
+	log.Fatal(http.ListenAndServe(*httpAddr, newHandler(s)))
+}
+
+// backend stores every message received by the SMTP server.
+type backend struct {
+	store *store
+}
+
+func (be *backend) NewSession(conn *server.Conn) (server.Session, error) {
+	return &session{store: be.store}, nil
+}
+
+// session is an SMTP session accepting any sender, recipient and credentials.
+type session struct {
+	store *store
+	from  string
+	to    []string
+}
+
+func (s *session) Auth(username, password string) error {
+	return nil
+}
+
+func (s *session) Mail(from string, opts *server.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *session) Rcpt(to string) error {
+	s.to = append(s.to, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	stored, err := s.store.add(s.from, s.to, raw)
+	if err != nil {
+		return err
+	}
+	log.Printf("m-mail-catcher: received %s from %s to %v", stored.ID, stored.From, stored.To)
+	return nil
+}
+
+func (s *session) Reset() {
+	s.from, s.to = "", nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
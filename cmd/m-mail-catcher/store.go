@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// storedMessage is a message received by the catcher.
+type storedMessage struct {
+	ID       string    `json:"id"`
+	From     string    `json:"from"`
+	To       []string  `json:"to"`
+	Subject  string    `json:"subject"`
+	Received time.Time `json:"received"`
+	Size     int       `json:"size"`
+
+	raw []byte
+}
+
+// Message parses the raw message.
+func (stored *storedMessage) Message() (*message.Message, error) {
+	return message.Parse(bytes.NewReader(stored.raw))
+}
+
+// store keeps the received messages in memory, and in dir if it is not empty.
+type store struct {
+	dir string
+
+	mu       sync.RWMutex
+	messages []*storedMessage
+	lastID   int64
+}
+
+// openStore returns a store, loading the messages saved in dir if any.
+func openStore(dir string) (*store, error) {
+	s := &store{dir: dir}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		stored := &storedMessage{}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, stored); err != nil {
+			return nil, err
+		}
+		if stored.raw, err = os.ReadFile(strings.TrimSuffix(path, ".json") + ".eml"); err != nil {
+			return nil, err
+		}
+		s.messages = append(s.messages, stored)
+	}
+	sort.Slice(s.messages, func(i, j int) bool {
+		return s.messages[i].Received.Before(s.messages[j].Received)
+	})
+
+	return s, nil
+}
+
+// add stores a received message.
+func (s *store) add(from string, to []string, raw []byte) (*storedMessage, error) {
+	stored := &storedMessage{From: from, To: to, Received: time.Now(), Size: len(raw), raw: raw}
+	if msg, err := message.Parse(bytes.NewReader(raw)); err == nil {
+		stored.Subject = msg.GetSubject()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// IDs grow with time so they stay unique across restarts.
+	id := stored.Received.UnixNano()
+	if id <= s.lastID {
+		id = s.lastID + 1
+	}
+	s.lastID = id
+	stored.ID = strconv.FormatInt(id, 36)
+
+	if s.dir != "" {
+		meta, err := json.Marshal(stored)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, stored.ID+".eml"), raw, 0600); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, stored.ID+".json"), meta, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	s.messages = append(s.messages, stored)
+	return stored, nil
+}
+
+// list returns the messages, the most recent first.
+func (s *store) list() []*storedMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make([]*storedMessage, len(s.messages))
+	for index, stored := range s.messages {
+		messages[len(messages)-1-index] = stored
+	}
+	return messages
+}
+
+// get returns the message with the given id, or nil.
+func (s *store) get(id string) *storedMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, stored := range s.messages {
+		if stored.ID == id {
+			return stored
+		}
+	}
+	return nil
+}
+
+// clear deletes all the messages.
+func (s *store) clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		for _, stored := range s.messages {
+			for _, ext := range []string{".eml", ".json"} {
+				if err := os.Remove(filepath.Join(s.dir, stored.ID+ext)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+	s.messages = nil
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ishail/m-mail/common"
+)
+
+var pages = template.Must(template.New("").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>m-mail-catcher</title><style>
+body{font-family:sans-serif;margin:0 2em}table{border-collapse:collapse;width:100%}
+td,th{border-bottom:1px solid #ddd;padding:.4em;text-align:left;vertical-align:top}
+pre{white-space:pre-wrap;background:#f6f6f6;padding:1em}iframe{width:100%;height:30em;border:1px solid #ddd}
+</style></head><body><h1><a href="/">m-mail-catcher</a></h1>{{end}}
+
+{{define "list"}}{{template "head"}}
+<form method="post" action="/clear"><button type="submit">Delete all</button></form>
+<table><tr><th>Received</th><th>From</th><th>To</th><th>Subject</th><th>Size</th></tr>
+{{range .}}<tr><td>{{.Received.Format "2006-01-02 15:04:05"}}</td><td>{{.From}}</td>
+<td>{{join .To ", "}}</td><td><a href="/messages/{{.ID}}">{{or .Subject "(no subject)"}}</a></td><td>{{.Size}}</td></tr>
+{{else}}<tr><td colspan="5">No message yet.</td></tr>{{end}}
+</table></body></html>{{end}}
+
+{{define "message"}}{{template "head"}}
+<h2>{{or .Subject "(no subject)"}}</h2>
+<p>From {{.From}} to {{join .To ", "}} &middot; <a href="/api/messages/{{.ID}}/raw">Source</a></p>
+<table>{{range .Headers}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}</table>
+{{if .HTML}}<h3>HTML</h3><iframe sandbox src="/messages/{{.ID}}/html"></iframe>{{end}}
+{{if .Text}}<h3>Text</h3><pre>{{.Text}}</pre>{{end}}
+{{if .Attachments}}<h3>Attachments</h3><ul>{{range .Attachments}}
+<li><a href="/messages/{{$.ID}}/attachments/{{.Index}}">{{.Name}}</a> ({{.ContentType}}, {{.Size}} bytes{{if .Embedded}}, embedded{{end}})</li>{{end}}</ul>{{end}}
+</body></html>{{end}}
+`))
+
+// header is a header of a message.
+type header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// attachment describes an attached or embedded file of a message.
+type attachment struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Embedded    bool   `json:"embedded"`
+
+	// contentID is the Content-ID of the file, without its angle brackets,
+	// which the HTML part refers to with a cid: URL.
+	contentID string
+	content   []byte
+}
+
+// details is a message with its headers and parts.
+type details struct {
+	*storedMessage
+	Headers     []header     `json:"headers"`
+	Text        string       `json:"text"`
+	HTML        string       `json:"html"`
+	Attachments []attachment `json:"attachments"`
+}
+
+// newHandler returns the handler of the web UI and the JSON API.
+func newHandler(s *store) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		render(w, "list", s.list())
+	})
+
+	mux.HandleFunc("/clear", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.clear(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		id, rest := splitPath(strings.TrimPrefix(r.URL.Path, "/messages/"))
+		d, ok := getDetails(w, r, s, id)
+		if !ok {
+			return
+		}
+
+		switch {
+		case rest == "":
+			render(w, "message", d)
+		case rest == "html":
+			// The HTML part is shown in a sandboxed iframe, with its embedded
+			// images served by the catcher.
+			html := d.HTML
+			for _, file := range d.Attachments {
+				if file.Embedded && file.contentID != "" {
+					html = strings.ReplaceAll(html, "cid:"+file.contentID,
+						"/messages/"+d.ID+"/attachments/"+strconv.Itoa(file.Index))
+				}
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", "sandbox")
+			w.Write([]byte(html))
+		case strings.HasPrefix(rest, "attachments/"):
+			index, err := strconv.Atoi(strings.TrimPrefix(rest, "attachments/"))
+			if err != nil || index < 0 || index >= len(d.Attachments) {
+				http.NotFound(w, r)
+				return
+			}
+			// The files are downloaded rather than shown, and are not run as
+			// pages of the catcher if they are opened anyway.
+			file := d.Attachments[index]
+			w.Header().Set("Content-Type", file.ContentType)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Content-Security-Policy", "sandbox")
+			w.Write(file.content)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	mux.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, s.list())
+		case http.MethodDelete:
+			if err := s.clear(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api/messages/", func(w http.ResponseWriter, r *http.Request) {
+		id, rest := splitPath(strings.TrimPrefix(r.URL.Path, "/api/messages/"))
+		switch rest {
+		case "":
+			if d, ok := getDetails(w, r, s, id); ok {
+				writeJSON(w, d)
+			}
+		case "raw":
+			stored := s.get(id)
+			if stored == nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(stored.raw)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	return mux
+}
+
+//Return the details of the message with the given id, replying an error if it
+//cannot be read
+func getDetails(w http.ResponseWriter, r *http.Request, s *store, id string) (*details, bool) {
+	stored := s.get(id)
+	if stored == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	d, err := newDetails(stored)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return d, true
+}
+
+//Parse a stored message into its headers and parts
+func newDetails(stored *storedMessage) (*details, error) {
+	d := &details{storedMessage: stored}
+
+	raw, err := mail.ReadMessage(bytes.NewReader(stored.raw))
+	if err != nil {
+		return nil, err
+	}
+	decoder := &mime.WordDecoder{}
+	names := make([]string, 0, len(raw.Header))
+	for name := range raw.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range raw.Header[name] {
+			if decoded, err := decoder.DecodeHeader(value); err == nil {
+				value = decoded
+			}
+			d.Headers = append(d.Headers, header{name, value})
+		}
+	}
+
+	msg, err := stored.Message()
+	if err != nil {
+		return nil, err
+	}
+
+	body, bodyType := msg.GetBody()
+	setContent(d, bodyType, body)
+	for _, part := range msg.GetAlternatives() {
+		var buff bytes.Buffer
+		part.Copier(&buff)
+		setContent(d, part.ContentType, buff.String())
+	}
+
+	addFiles(d, msg.GetAttachments(), false)
+	addFiles(d, msg.GetEmbedded(), true)
+	return d, nil
+}
+
+//Keep the first text and HTML contents of a message
+func setContent(d *details, contentType, content string) {
+	switch {
+	case contentType == "text/plain" && d.Text == "":
+		d.Text = content
+	case contentType == "text/html" && d.HTML == "":
+		d.HTML = content
+	}
+}
+
+func addFiles(d *details, files []*common.File, embedded bool) {
+	for _, file := range files {
+		var buff bytes.Buffer
+		file.CopyFunc(&buff)
+
+		contentType := "application/octet-stream"
+		if values := file.Header["Content-Type"]; len(values) > 0 {
+			if mediaType, _, err := mime.ParseMediaType(values[0]); err == nil {
+				contentType = mediaType
+			}
+		}
+
+		var contentID string
+		if values := file.Header["Content-ID"]; len(values) > 0 {
+			contentID = strings.Trim(values[0], "<>")
+		}
+
+		d.Attachments = append(d.Attachments, attachment{
+			Index:       len(d.Attachments),
+			Name:        file.Name,
+			ContentType: contentType,
+			Size:        buff.Len(),
+			Embedded:    embedded,
+			contentID:   contentID,
+			content:     buff.Bytes(),
+		})
+	}
+}
+
+//Split the id of a message from the rest of a path
+func splitPath(path string) (string, string) {
+	if index := strings.IndexByte(path, '/'); index >= 0 {
+		return path[:index], path[index+1:]
+	}
+	return path, ""
+}
+
+func render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
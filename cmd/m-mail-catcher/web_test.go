@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/common"
+	"github.com/ishail/m-mail/message"
+)
+
+func TestEmbeddedFiles(t *testing.T) {
+	msg := message.NewMessage("Hello", `<p>Hi <img src="cid:logo@example.com"></p>`, "text/html")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+	msg.Embed("logo.png",
+		message.SetFileHeader(common.Header{"Content-ID": {"<logo@example.com>"}}),
+		message.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write([]byte("<script>alert(1)</script>"))
+			return err
+		}))
+
+	var raw bytes.Buffer
+	if _, err := msg.WriteTo(&raw); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.add("alice@example.com", []string{"bob@example.com"}, raw.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(newHandler(s))
+	defer srv.Close()
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	_, html := get("/messages/" + stored.ID + "/html")
+	if want := `<img src="/messages/` + stored.ID + `/attachments/0">`; !strings.Contains(html, want) {
+		t.Errorf("the cid: URL was not replaced:\n%s", html)
+	}
+
+	resp, _ := get("/messages/" + stored.ID + "/attachments/0")
+	for name, want := range map[string]string{
+		"Content-Disposition":     `attachment; filename=logo.png`,
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	} {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+}
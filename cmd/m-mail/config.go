@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/ishail/m-mail/sender"
+)
+
+// serverFlags are the flags configuring the SMTP server, which take precedence
+// over the configuration file and the environment.
+type serverFlags struct {
+	host      string
+	port      int
+	username  string
+	password  string
+	ssl       bool
+	localName string
+	proxy     string
+}
+
+//Return a dialer configured by the profile of the file at path, the
+//environment and the flags, in increasing order of precedence
+func newDialer(path, profile string, flags *serverFlags) (*sender.Dialer, error) {
+	cfg, err := sender.LoadConfig(path, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags.host != "" {
+		cfg.Host = flags.host
+	}
+	if flags.port != 0 {
+		cfg.Port = flags.port
+	}
+	if flags.username != "" {
+		cfg.Username = flags.username
+	}
+	if flags.password != "" {
+		cfg.Password = flags.password
+	}
+	if flags.localName != "" {
+		cfg.LocalName = flags.localName
+	}
+	if flags.proxy != "" {
+		cfg.Proxy = flags.proxy
+	}
+	cfg.SSL = cfg.SSL || flags.ssl
+
+	return cfg.Dialer()
+}
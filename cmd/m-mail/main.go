@@ -0,0 +1,268 @@
+/*
+	Command m-mail composes a message and sends it through an SMTP server.
+
+	Usage:
+
+		m-mail [flags] --from addr --to addr [--to addr...] --subject text --text-file file
+		m-mail [flags] --raw < message.eml
+
+	The message is built from the flags, or read from the standard input with
+	--raw. A raw message is streamed to the SMTP server as is, except for its
+	Bcc header which is removed and the headers of --header which are added;
+	--from, --to, --cc and --bcc then only set its envelope, which defaults to
+	its From, To, Cc and Bcc headers. The SMTP server is configured by the flags, the MMAIL_*
+	environment variables or the YAML, TOML or JSON file given with --config,
+	in that order of precedence. A profile of the file can be selected with
+	--profile, see sender.LoadConfig. The outcome is reported for each
+	recipient, as text or as JSON with --json.
+
+	The exit status is 0 when every recipient was sent the message, 3 when some
+	of them failed and 1 on errors.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+)
+
+// stringList is a flag that can be repeated, whose values can also be
+// separated by commas.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
+
+// headerList is a flag holding "Name: value" headers, that can be repeated.
+type headerList []string
+
+func (list *headerList) String() string {
+	return strings.Join(*list, "\n")
+}
+
+func (list *headerList) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("invalid header %q, expected Name: value", value)
+	}
+	*list = append(*list, value)
+	return nil
+}
+
+// recipientOutput is the outcome of a recipient, as printed with --json.
+type recipientOutput struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var to, cc, bcc, attachments stringList
+	var headers headerList
+
+	flags := flag.NewFlagSet("m-mail", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	from := flags.String("from", "", "sender `address`")
+	flags.Var(&to, "to", "recipient `address`, can be repeated")
+	flags.Var(&cc, "cc", "carbon copy `address`, can be repeated")
+	flags.Var(&bcc, "bcc", "blind carbon copy `address`, can be repeated")
+	subject := flags.String("subject", "", "`subject` of the message")
+	htmlFile := flags.String("html-file", "", "`file` holding the HTML body, - for the standard input")
+	textFile := flags.String("text-file", "", "`file` holding the text body, - for the standard input")
+	flags.Var(&attachments, "attach", "`file` to attach, can be repeated")
+	flags.Var(&headers, "header", "`header` to add, as \"Name: value\", can be repeated")
+	raw := flags.Bool("raw", false, "read the message from the standard input")
+	asJSON := flags.Bool("json", false, "report the outcome as JSON")
+
+	server := &serverFlags{}
+	configFile := flags.String("config", "", "YAML, TOML or JSON configuration `file` of the SMTP server")
+	profile := flags.String("profile", "", "`name` of the profile of the configuration file")
+	flags.StringVar(&server.host, "host", "", "`host` of the SMTP server")
+	flags.IntVar(&server.port, "port", 0, "`port` of the SMTP server, 587 by default")
+	flags.StringVar(&server.username, "username", "", "`username` to authenticate with")
+	flags.StringVar(&server.password, "password", "", "`password` to authenticate with")
+	flags.BoolVar(&server.ssl, "ssl", false, "connect with implicit TLS")
+	flags.StringVar(&server.localName, "local-name", "", "`hostname` sent with HELO")
+	flags.StringVar(&server.proxy, "proxy", "", "`url` of a SOCKS5 or HTTP proxy")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	dialer, err := newDialer(*configFile, *profile, server)
+	if err != nil {
+		fmt.Fprintln(stderr, "m-mail:", err)
+		return 1
+	}
+
+	var send func(sender.SendCloser) (*sender.Result, error)
+	if *raw {
+		if *textFile == "-" || *htmlFile == "-" {
+			fmt.Fprintln(stderr, "m-mail: --raw already reads the message from the standard input")
+			return 2
+		}
+		recipients := append(append(append([]string(nil), to...), cc...), bcc...)
+		msg, err := readRawMessage(stdin, *from, recipients, headers)
+		if err != nil {
+			fmt.Fprintln(stderr, "m-mail:", err)
+			return 1
+		}
+		send = func(sendCloser sender.SendCloser) (*sender.Result, error) {
+			rawSender, ok := sendCloser.(sender.RawSender)
+			if !ok {
+				return nil, errors.New("the sender cannot send raw messages")
+			}
+			return rawSender.SendRaw(msg.from, msg.to, msg.Reader())
+		}
+	} else {
+		msg, err := compose(stdin, *subject, *textFile, *htmlFile, attachments)
+		if err != nil {
+			fmt.Fprintln(stderr, "m-mail:", err)
+			return 1
+		}
+		setHeaders(msg, *from, to, cc, bcc, headers)
+		send = func(sendCloser sender.SendCloser) (*sender.Result, error) {
+			return sendCloser.Send(msg)
+		}
+	}
+
+	sendCloser, err := dialer.Dial()
+	if err != nil {
+		fmt.Fprintln(stderr, "m-mail:", err)
+		return 1
+	}
+	defer sendCloser.Close()
+
+	result, err := send(sendCloser)
+	if result != nil {
+		report(stdout, result, *asJSON)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "m-mail:", err)
+		return 1
+	}
+	if len(result.Addresses(sender.Sent)) != len(result.Recipients) {
+		return 3
+	}
+	return 0
+}
+
+//Build a message from its parts
+func compose(stdin io.Reader, subject, textFile, htmlFile string, attachments []string) (*message.Message, error) {
+	in := &inputs{stdin: stdin}
+	text, err := in.readFile(textFile)
+	if err != nil {
+		return nil, err
+	}
+	html, err := in.readFile(htmlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg *message.Message
+	switch {
+	case htmlFile != "" && textFile != "":
+		msg = message.NewMessage(subject, text, "text/plain")
+		msg.AddAlternative("text/html", html)
+	case htmlFile != "":
+		msg = message.NewMessage(subject, html, "text/html")
+	default:
+		msg = message.NewMessage(subject, text, "text/plain")
+	}
+
+	for _, path := range attachments {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		msg.Attach(path)
+	}
+	return msg, nil
+}
+
+// inputs reads the files given to the flags, or the standard input for "-",
+// which can only be given once.
+type inputs struct {
+	stdin     io.Reader
+	stdinRead bool
+}
+
+//Read a file, or the standard input if path is "-"
+func (in *inputs) readFile(path string) (string, error) {
+	var content []byte
+	var err error
+	switch path {
+	case "":
+		return "", nil
+	case "-":
+		if in.stdinRead {
+			return "", errors.New("the standard input can only be read for one input")
+		}
+		in.stdinRead = true
+		content, err = io.ReadAll(in.stdin)
+	default:
+		content, err = os.ReadFile(path)
+	}
+	return string(content), err
+}
+
+//Set the headers given by the flags
+func setHeaders(msg *message.Message, from string, to, cc, bcc, headers []string) {
+	if from != "" {
+		msg.SetAddressHeader("From", from, "")
+	}
+	for field, addresses := range map[string][]string{"To": to, "Cc": cc, "Bcc": bcc} {
+		if len(addresses) > 0 {
+			msg.SetHeader(field, addresses...)
+		}
+	}
+	for _, header := range headers {
+		index := strings.IndexByte(header, ':')
+		msg.SetHeader(strings.TrimSpace(header[:index]), strings.TrimSpace(header[index+1:]))
+	}
+}
+
+//Print the outcome of each recipient
+func report(w io.Writer, result *sender.Result, asJSON bool) {
+	if !asJSON {
+		fmt.Fprintln(w, result)
+		return
+	}
+
+	outputs := make([]recipientOutput, len(result.Recipients))
+	for index, recipient := range result.Recipients {
+		outputs[index] = recipientOutput{
+			Address: recipient.Address,
+			Status:  string(recipient.Status),
+			Reason:  string(recipient.Reason),
+		}
+		if recipient.Err != nil {
+			outputs[index].Error = recipient.Err.Error()
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(map[string]interface{}{"recipients": outputs})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/smtptest"
+)
+
+//Run the command against srv and return its exit status and standard error
+func runWith(srv *smtptest.Server, stdin string, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"--host", srv.Host, "--port", strconv.Itoa(srv.Port)}, args...)
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stderr.String()
+}
+
+func TestRunCompose(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	htmlFile := filepath.Join(t.TempDir(), "body.html")
+	if err := os.WriteFile(htmlFile, []byte("<p>Hi Bob</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stderr := runWith(srv, "Hi Bob", "--from", "alice@example.com", "--to", "bob@example.com",
+		"--subject", "Hello", "--text-file", "-", "--html-file", htmlFile, "--header", "X-Campaign: spring")
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+	data := string(transactions[0].Data)
+	for _, want := range []string{"Subject: Hello\n", "X-Campaign: spring\n", "Hi Bob\n", "<p>Hi Bob</p>"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("%q missing from the message:\n%s", want, data)
+		}
+	}
+}
+
+func TestRunStdinOnce(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	code, stderr := runWith(srv, "Hi", "--from", "alice@example.com", "--to", "bob@example.com",
+		"--text-file", "-", "--html-file", "-")
+	if code == 0 || !strings.Contains(stderr, "standard input") {
+		t.Fatalf("exit status %d: %s", code, stderr)
+	}
+
+	code, stderr = runWith(srv, "Hi", "--raw", "--text-file", "-")
+	if code == 0 || !strings.Contains(stderr, "standard input") {
+		t.Fatalf("exit status %d: %s", code, stderr)
+	}
+
+	if transactions := srv.Transactions(); len(transactions) != 0 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+}
+
+func TestRunRaw(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	// The message is not valid MIME, which would not survive being parsed and
+	// rendered again.
+	raw := "From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Bcc: carol@example.com,\r\n" +
+		"\tdave@example.com\r\n" +
+		"Subject:   Hello\r\n" +
+		"Content-Type: multipart/mixed\r\n" +
+		"\r\n" +
+		"Body \xff kept as is\r\n"
+
+	code, stderr := runWith(srv, raw, "--raw", "--header", "X-Campaign: spring")
+	if code != 0 {
+		t.Fatalf("exit status %d: %s", code, stderr)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+	tx := transactions[0]
+	if tx.From != "alice@example.com" || strings.Join(tx.To, ",") != "bob@example.com,carol@example.com,dave@example.com" {
+		t.Errorf("unexpected envelope %s %v", tx.From, tx.To)
+	}
+
+	want := "X-Campaign: spring\n" +
+		"From: Alice <alice@example.com>\n" +
+		"To: bob@example.com\n" +
+		"Subject:   Hello\n" +
+		"Content-Type: multipart/mixed\n" +
+		"\n" +
+		"Body \xff kept as is\n"
+	if string(tx.Data) != want {
+		t.Errorf("got %q, want %q", tx.Data, want)
+	}
+}
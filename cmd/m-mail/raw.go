@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+// rawMessage is a message read from the standard input with --raw, which is
+// sent as is. Only its header section is read before sending, the rest is
+// streamed to the SMTP server.
+type rawMessage struct {
+	from   string
+	to     []string
+	header []byte
+	body   io.Reader
+}
+
+// Reader returns the message, with the Bcc header removed and the headers of
+// --header added.
+func (msg *rawMessage) Reader() io.Reader {
+	return io.MultiReader(bytes.NewReader(msg.header), msg.body)
+}
+
+//Read the header section of a raw message and determine its envelope: the
+//flags take precedence over the From, To, Cc and Bcc headers of the message
+func readRawMessage(stdin io.Reader, from string, to []string, headers []string) (*rawMessage, error) {
+	r := bufio.NewReader(stdin)
+	var header []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		header = append(header, line...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(header))
+	if err != nil {
+		return nil, err
+	}
+
+	if from == "" {
+		addresses, err := parsed.Header.AddressList("From")
+		if err != nil || len(addresses) == 0 {
+			return nil, errors.New("the message has no sender, use --from")
+		}
+		from = addresses[0].Address
+	}
+	if len(to) == 0 {
+		for _, field := range []string{"To", "Cc", "Bcc"} {
+			addresses, err := parsed.Header.AddressList(field)
+			if err != nil && err != mail.ErrHeaderNotPresent {
+				return nil, err
+			}
+			for _, addr := range addresses {
+				to = append(to, addr.Address)
+			}
+		}
+	}
+	if len(to) == 0 {
+		return nil, errors.New("the message has no recipient, use --to")
+	}
+
+	return &rawMessage{from: from, to: to, header: rewriteHeader(header, headers), body: r}, nil
+}
+
+//Add headers to a header section and remove its Bcc header
+func rewriteHeader(header []byte, added []string) []byte {
+	var out bytes.Buffer
+	for _, line := range added {
+		index := strings.IndexByte(line, ':')
+		out.WriteString(strings.TrimSpace(line[:index]) + ": " + strings.TrimSpace(line[index+1:]) + "\r\n")
+	}
+
+	skipping := false
+	for len(header) > 0 {
+		end := bytes.IndexByte(header, '\n') + 1
+		if end == 0 {
+			end = len(header)
+		}
+		line := header[:end]
+		header = header[end:]
+
+		if line[0] != ' ' && line[0] != '\t' {
+			colon := bytes.IndexByte(line, ':')
+			skipping = colon > 0 && strings.EqualFold(strings.TrimSpace(string(line[:colon])), "Bcc")
+		}
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,246 @@
+/*
+	Command m-sendmail is a sendmail compatible command relaying the messages
+	read on its standard input through an SMTP server, so that it can replace
+	/usr/sbin/sendmail.
+
+	Usage:
+
+		m-sendmail [-t] [-i] [-f sender] [-F name] [-C file] [recipient...]
+		m-sendmail -bs
+
+	The supported flags are:
+
+		-t       read the recipients from the To, Cc and Bcc headers
+		-i, -oi  do not end the message at a line with a single dot
+		-f addr  set the envelope sender
+		-F name  set the name of the sender, used when the message has no From
+		-C file  read the configuration of the SMTP server from file
+		-bs      speak SMTP on the standard input and output
+		-bm      read a message on the standard input, the default
+
+	The other -o flags are ignored. The SMTP server is configured by the
+	YAML, TOML or JSON file given with -C or the MMAIL_CONFIG environment
+	variable, and by the MMAIL_* environment variables, see
+	sender.LoadConfig.
+
+	The exit status follows sysexits.h, as sendmail does.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/ishail/m-mail/sender"
+)
+
+// Exit codes defined in sysexits.h.
+const (
+	exitOK          = 0
+	exitUsage       = 64
+	exitDataErr     = 65
+	exitNoUser      = 67
+	exitUnavailable = 69
+	exitSoftware    = 70
+	exitTempFail    = 75
+	exitConfig      = 78
+)
+
+// options are the options of the command line.
+type options struct {
+	extractRecipients bool
+	ignoreDots        bool
+	from              string
+	fullName          string
+	smtpMode          bool
+	configFile        string
+	recipients        []string
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		os.Exit(exitUsage)
+	}
+
+	dialer, err := newDialer(opts.configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		os.Exit(exitConfig)
+	}
+
+	if opts.smtpMode {
+		os.Exit(serveStdio(dialer, opts))
+	}
+	os.Exit(relayStdin(dialer, opts))
+}
+
+//Parse the sendmail flags
+func parseArgs(args []string) (*options, error) {
+	opts := &options{}
+
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if arg == "--" {
+			opts.recipients = append(opts.recipients, args[index+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			opts.recipients = append(opts.recipients, arg)
+			continue
+		}
+
+		// The value of -f and -F can be attached to the flag or be the next
+		// argument.
+		value := func() (string, error) {
+			if len(arg) > 2 {
+				return arg[2:], nil
+			}
+			if index+1 >= len(args) {
+				return "", fmt.Errorf("missing value of %s", arg)
+			}
+			index++
+			return args[index], nil
+		}
+
+		var err error
+		switch {
+		case arg == "-t":
+			opts.extractRecipients = true
+		case arg == "-i" || arg == "-oi":
+			opts.ignoreDots = true
+		case strings.HasPrefix(arg, "-f"), strings.HasPrefix(arg, "-r"):
+			opts.from, err = value()
+		case strings.HasPrefix(arg, "-F"):
+			opts.fullName, err = value()
+		case strings.HasPrefix(arg, "-C"):
+			opts.configFile, err = value()
+		case arg == "-bs":
+			opts.smtpMode = true
+		case arg == "-bm", strings.HasPrefix(arg, "-o"), arg == "-v", arg == "-U":
+			// Ignored, as by most sendmail replacements.
+		default:
+			err = fmt.Errorf("unsupported flag %s", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+//Return a dialer configured by the file named by MMAIL_CONFIG, if any, and the
+//environment
+func newDialer(path string) (*sender.Dialer, error) {
+	if path == "" {
+		path = os.Getenv("MMAIL_CONFIG")
+	}
+
+	cfg, err := sender.LoadConfig(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Dialer()
+}
+
+//Read a message on the standard input and relay it
+func relayStdin(dialer *sender.Dialer, opts *options) int {
+	raw, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		return exitDataErr
+	}
+	if !opts.ignoreDots {
+		raw = cutAtDot(raw)
+	}
+
+	msg, err := prepare(raw, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		return exitDataErr
+	}
+	if len(msg.to) == 0 {
+		fmt.Fprintln(os.Stderr, "m-sendmail: no recipient")
+		return exitUsage
+	}
+
+	return relay(dialer, msg.from, msg.to, msg.data)
+}
+
+//Send a message through the SMTP server and report the failures
+func relay(dialer *sender.Dialer, from string, to []string, data []byte) int {
+	result, code := send(dialer, from, to, data)
+	if result == nil {
+		return code
+	}
+	return report(result)
+}
+
+//Send a message through the SMTP server, returning the result of the
+//recipients or the exit code of the failure
+func send(dialer *sender.Dialer, from string, to []string, data []byte) (*sender.Result, int) {
+	sendCloser, err := dialer.Dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		return nil, exitCode(err, exitUnavailable)
+	}
+	defer sendCloser.Close()
+
+	rawSender, ok := sendCloser.(sender.RawSender)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "m-sendmail: the sender cannot relay raw messages")
+		return nil, exitSoftware
+	}
+
+	result, err := rawSender.SendRaw(from, to, bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "m-sendmail:", err)
+		return nil, exitCode(err, exitUnavailable)
+	}
+	return result, exitOK
+}
+
+//Report the recipients that were not sent the message and return the exit code
+//of the result
+func report(result *sender.Result) int {
+	code := exitOK
+	for _, recipient := range result.Recipients {
+		if recipient.Status == sender.Sent {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "m-sendmail: %s: %s\n", recipient.Address, recipient.Status)
+		if recipient.Err != nil {
+			fmt.Fprintf(os.Stderr, "m-sendmail: %s: %v\n", recipient.Address, recipient.Err)
+		}
+		if recipientCode := exitCode(recipient.Err, exitNoUser); code == exitOK || recipientCode == exitTempFail {
+			code = recipientCode
+		}
+	}
+	return code
+}
+
+//Map an error to an exit code, temporary SMTP errors to EX_TEMPFAIL
+func exitCode(err error, defaultCode int) int {
+	if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code >= 400 && protoErr.Code < 500 {
+		return exitTempFail
+	}
+	return defaultCode
+}
+
+//Return the login of the user running the command
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "nobody"
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/server"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"-t", "-oi", "-fbounce@example.com", "-F", "Alice", "-oem", "-C", "mail.yaml",
+		"bob@example.com", "--", "-carol@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &options{
+		extractRecipients: true,
+		ignoreDots:        true,
+		from:              "bounce@example.com",
+		fullName:          "Alice",
+		configFile:        "mail.yaml",
+		recipients:        []string{"bob@example.com", "-carol@example.com"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("parseArgs() = %+v, want %+v", opts, want)
+	}
+
+	for _, args := range [][]string{{"-f"}, {"-X"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%q) succeeded", args)
+		}
+	}
+}
+
+func TestCutAtDot(t *testing.T) {
+	tests := map[string]string{
+		"Subject: Hi\n\nHello\n.\nIgnored\n": "Subject: Hi\n\nHello\n",
+		"Subject: Hi\r\n\r\nHello\r\n.\r\n":  "Subject: Hi\r\n\r\nHello\r\n",
+		"Subject: Hi\n\n..\n.Hello\n":        "Subject: Hi\n\n..\n.Hello\n",
+	}
+	for raw, want := range tests {
+		if got := string(cutAtDot([]byte(raw))); got != want {
+			t.Errorf("cutAtDot(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	raw := "To: bob@example.com\r\nCc: Carol <carol@example.com>, bob@example.com\r\n" +
+		"Bcc: dave@example.com,\r\n erin@example.com\r\nSubject: Hello\r\n\r\nBcc: kept in the body\r\n"
+
+	msg, err := prepare([]byte(raw), &options{extractRecipients: true, from: "alice@example.com", fullName: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.from != "alice@example.com" {
+		t.Errorf("from = %q", msg.from)
+	}
+	wantTo := []string{"bob@example.com", "carol@example.com", "dave@example.com", "erin@example.com"}
+	if !reflect.DeepEqual(msg.to, wantTo) {
+		t.Errorf("to = %v, want %v", msg.to, wantTo)
+	}
+
+	data := string(msg.data)
+	if !strings.HasPrefix(data, "From: \"Alice\" <alice@example.com>\r\nDate: ") {
+		t.Errorf("From and Date headers not added:\n%s", data)
+	}
+	if strings.Contains(data, "dave@example.com") || strings.Contains(data, "erin@example.com") {
+		t.Errorf("Bcc header not removed:\n%s", data)
+	}
+	if !strings.HasSuffix(data, "Subject: Hello\r\n\r\nBcc: kept in the body\r\n") {
+		t.Errorf("message changed:\n%s", data)
+	}
+}
+
+func TestPrepareKeepsHeaders(t *testing.T) {
+	raw := "From: bounce@example.com\r\nDate: Mon, 02 Jan 2006 15:04:05 +0000\r\nTo: bob@example.com\r\n\r\nHi\r\n"
+
+	msg, err := prepare([]byte(raw), &options{recipients: []string{"carol@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.from != "bounce@example.com" || !reflect.DeepEqual(msg.to, []string{"carol@example.com"}) {
+		t.Errorf("unexpected envelope %q %v", msg.from, msg.to)
+	}
+	if string(msg.data) != raw {
+		t.Errorf("message changed:\n%s", msg.data)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	srv.OnRcpt = func(to string) error {
+		switch to {
+		case "unknown@example.com":
+			return &server.Error{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"}
+		case "full@example.com":
+			return &server.Error{Code: 452, EnhancedCode: "4.2.2", Message: "Mailbox full"}
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	data := []byte("Subject: Hello\r\n\r\nHi\r\n")
+	tests := []struct {
+		to        []string
+		code      int
+		delivered bool
+	}{
+		{[]string{"bob@example.com"}, exitOK, true},
+		{[]string{"bob@example.com", "unknown@example.com"}, exitNoUser, true},
+		{[]string{"unknown@example.com", "full@example.com"}, exitTempFail, false},
+	}
+	for _, test := range tests {
+		srv.Reset()
+		if code := relay(srv.Dialer(), "alice@example.com", test.to, data); code != test.code {
+			t.Errorf("relay to %v = %d, want %d", test.to, code, test.code)
+		}
+		transactions := srv.Transactions()
+		if delivered := len(transactions) == 1 && string(transactions[0].Data) == "Subject: Hello\n\nHi\n"; delivered != test.delivered {
+			t.Errorf("relay to %v: unexpected transactions %v", test.to, transactions)
+		}
+	}
+}
+
+func TestSessionData(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	srv.OnRcpt = func(to string) error {
+		if to == "unknown@example.com" {
+			return &server.Error{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"}
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		to       []string
+		accepted bool
+	}{
+		{[]string{"bob@example.com", "unknown@example.com"}, true},
+		{[]string{"unknown@example.com"}, false},
+	}
+	for _, test := range tests {
+		s := &session{dialer: srv.Dialer(), from: "alice@example.com", to: test.to}
+		if err := s.Data(strings.NewReader("Subject: Hello\r\n\r\nHi\r\n")); (err == nil) != test.accepted {
+			t.Errorf("Data() to %v = %v", test.to, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ishail/m-mail/common"
+)
+
+// rawMessage is a message to relay with its envelope.
+type rawMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+//Return the message up to the line with a single dot, if any
+func cutAtDot(raw []byte) []byte {
+	for offset := 0; offset < len(raw); {
+		end := bytes.IndexByte(raw[offset:], '\n') + 1
+		if end == 0 {
+			end = len(raw) - offset
+		}
+		if string(bytes.TrimRight(raw[offset:offset+end], "\r\n")) == "." {
+			return raw[:offset]
+		}
+		offset += end
+	}
+	return raw
+}
+
+//Determine the envelope of a message and complete its headers: a From header
+//is added when missing, as well as a Date header, and the Bcc header is removed
+func prepare(raw []byte, opts *options) (*rawMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	to := append([]string(nil), opts.recipients...)
+	if opts.extractRecipients {
+		for _, field := range []string{"To", "Cc", "Bcc"} {
+			addresses, err := msg.Header.AddressList(field)
+			if err != nil && err != mail.ErrHeaderNotPresent {
+				return nil, err
+			}
+			for _, addr := range addresses {
+				to = common.AddStrToUniqueList(to, addr.Address)
+			}
+		}
+	}
+
+	from := opts.from
+	if from == "" {
+		if addresses, err := msg.Header.AddressList("From"); err == nil && len(addresses) > 0 {
+			from = addresses[0].Address
+		} else {
+			host, _ := os.Hostname()
+			from = currentUser() + "@" + host
+		}
+	}
+
+	var added []string
+	if msg.Header.Get("From") == "" {
+		added = append(added, "From: "+(&mail.Address{Name: opts.fullName, Address: from}).String())
+	}
+	if msg.Header.Get("Date") == "" {
+		added = append(added, "Date: "+common.FormatDate(time.Now()))
+	}
+
+	return &rawMessage{from: from, to: to, data: rewriteHeaders(raw, added, "Bcc")}, nil
+}
+
+//Add headers to a message and remove the given one, keeping the rest of the
+//message unchanged
+func rewriteHeaders(raw []byte, added []string, removed string) []byte {
+	var out bytes.Buffer
+	for _, header := range added {
+		out.WriteString(header + "\r\n")
+	}
+
+	skipping := false
+	for len(raw) > 0 {
+		end := bytes.IndexByte(raw, '\n') + 1
+		if end == 0 {
+			end = len(raw)
+		}
+		line := raw[:end]
+
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// The rest is the body of the message.
+			out.Write(raw)
+			return out.Bytes()
+		}
+
+		isContinuation := line[0] == ' ' || line[0] == '\t'
+		if !isContinuation {
+			colon := bytes.IndexByte(line, ':')
+			skipping = colon > 0 && strings.EqualFold(strings.TrimSpace(string(line[:colon])), removed)
+		}
+		if !skipping {
+			out.Write(line)
+		}
+		raw = raw[end:]
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/server"
+)
+
+//Speak SMTP on the standard input and output, relaying every message
+func serveStdio(dialer *sender.Dialer, opts *options) int {
+	srv := server.NewServer(&backend{dialer: dialer})
+	srv.ServeConn(&stdioConn{})
+	return exitOK
+}
+
+// backend relays the messages received with -bs.
+type backend struct {
+	dialer *sender.Dialer
+}
+
+func (be *backend) NewSession(conn *server.Conn) (server.Session, error) {
+	return &session{dialer: be.dialer}, nil
+}
+
+// session relays each of its transactions through the SMTP server.
+type session struct {
+	dialer *sender.Dialer
+	from   string
+	to     []string
+}
+
+func (s *session) Mail(from string, opts *server.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *session) Rcpt(to string) error {
+	s.to = append(s.to, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Once a recipient was sent the message, failing the transaction would make
+	// the client send it again to all of them, so the other recipients are only
+	// reported on the standard error.
+	result, code := send(s.dialer, s.from, s.to, data)
+	if result != nil {
+		code = report(result)
+		if len(result.Addresses(sender.Sent)) > 0 {
+			return nil
+		}
+	}
+	if code != exitOK {
+		if code == exitTempFail {
+			return &server.Error{Code: 451, EnhancedCode: "4.4.0", Message: "Relay failed, try again later"}
+		}
+		return &server.Error{Code: 554, EnhancedCode: "5.0.0", Message: fmt.Sprintf("Relay failed (%d)", code)}
+	}
+	return nil
+}
+
+func (s *session) Reset() {
+	s.from, s.to = "", nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+// stdioConn is a connection reading the standard input and writing the
+// standard output.
+type stdioConn struct{}
+
+// stdioAddr is the address of both ends of a stdioConn.
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+func (*stdioConn) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (*stdioConn) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (*stdioConn) Close() error                { return os.Stdin.Close() }
+func (*stdioConn) LocalAddr() net.Addr         { return stdioAddr{} }
+func (*stdioConn) RemoteAddr() net.Addr        { return stdioAddr{} }
+
+func (*stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (*stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (*stdioConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,197 @@
+package inliner
+
+import (
+	"strings"
+)
+
+// rule is a style rule of a stylesheet.
+type rule struct {
+	selectors    string
+	declarations []declaration
+}
+
+// declaration is a single property of a rule or a style attribute.
+type declaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// stylesheet is the result of parsing the content of a style element. At-rules
+// such as media queries cannot be inlined and are kept as written.
+type stylesheet struct {
+	rules   []rule
+	atRules []string
+}
+
+//Parse the content of a style element
+func parseStylesheet(css string) *stylesheet {
+	sheet := &stylesheet{}
+	css = stripComments(css)
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return sheet
+		}
+
+		if css[0] == '@' {
+			end := atRuleEnd(css)
+			sheet.atRules = append(sheet.atRules, strings.TrimSpace(css[:end]))
+			css = css[end:]
+			continue
+		}
+
+		open := strings.IndexByte(css, '{')
+		if open == -1 {
+			return sheet
+		}
+		// Like browsers, an unclosed rule runs to the end of the stylesheet.
+		end, closed := blockEnd(css, open)
+		body := css[open+1 : end]
+		if closed {
+			body = css[open+1 : end-1]
+		}
+		sheet.rules = append(sheet.rules, rule{
+			selectors:    strings.TrimSpace(css[:open]),
+			declarations: parseDeclarations(body),
+		})
+		css = css[end:]
+	}
+}
+
+//Parse the declarations of a rule or a style attribute
+func parseDeclarations(css string) []declaration {
+	var declarations []declaration
+	for _, decl := range splitOutside(css, ';') {
+		colon := strings.IndexByte(decl, ':')
+		if colon == -1 {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(decl[:colon]))
+		value := strings.TrimSpace(decl[colon+1:])
+		important := false
+		if index := strings.LastIndexByte(value, '!'); index != -1 &&
+			strings.EqualFold(strings.TrimSpace(value[index+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:index])
+		}
+		if property == "" || value == "" {
+			continue
+		}
+
+		declarations = append(declarations, declaration{property, value, important})
+	}
+
+	return declarations
+}
+
+//Format declarations as the value of a style attribute
+func formatDeclarations(declarations []declaration) string {
+	values := make([]string, len(declarations))
+	for index, decl := range declarations {
+		values[index] = decl.property + ": " + decl.value
+		if decl.important {
+			values[index] += " !important"
+		}
+	}
+	return strings.Join(values, "; ")
+}
+
+//Format rules back into CSS
+func formatRules(rules []rule) string {
+	var css strings.Builder
+	for _, r := range rules {
+		css.WriteString(r.selectors + " { " + formatDeclarations(r.declarations) + " }\n")
+	}
+	return css.String()
+}
+
+//Remove the comments of a stylesheet
+func stripComments(css string) string {
+	var buff strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start == -1 {
+			buff.WriteString(css)
+			return buff.String()
+		}
+		buff.WriteString(css[:start])
+
+		end := strings.Index(css[start+2:], "*/")
+		if end == -1 {
+			return buff.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+//Return the index following an at-rule, either a statement ending with a
+//semicolon or a block
+func atRuleEnd(css string) int {
+	semicolon := strings.IndexByte(css, ';')
+	open := strings.IndexByte(css, '{')
+	if open == -1 || (semicolon != -1 && semicolon < open) {
+		if semicolon == -1 {
+			return len(css)
+		}
+		return semicolon + 1
+	}
+	end, _ := blockEnd(css, open)
+	return end
+}
+
+//Return the index following the block opened at index open, and whether the
+//block is closed. An unclosed block ends with the stylesheet.
+func blockEnd(css string, open int) (int, bool) {
+	depth := 0
+	var quote byte
+	for i := open; i < len(css); i++ {
+		switch c := css[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return len(css), false
+}
+
+//Split a string on sep, ignoring the separators inside quotes and parentheses
+func splitOutside(value string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
@@ -0,0 +1,214 @@
+/*
+	Package inliner moves the CSS rules of the style elements of an HTML email into
+	the style attributes of the elements they apply to, since many mail clients
+	strip style elements.
+*/
+package inliner
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// match is a rule whose selector matches an element.
+type match struct {
+	specificity  int
+	order        int
+	declarations []declaration
+}
+
+// Inline applies the rules of the style elements of an HTML document to the
+// style attributes of the matching elements, respecting the specificity of the
+// selectors and the existing style attributes. Media queries and other at-rules,
+// as well as the rules whose selectors cannot be inlined (such as :hover), are
+// kept in a style element. Style elements with a media attribute other than
+// "all" or "screen" are left untouched.
+func Inline(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var styles []*html.Node
+	walk(doc, func(node *html.Node) {
+		if node.DataAtom == atom.Style && isInlinable(node) {
+			styles = append(styles, node)
+		}
+	})
+	if len(styles) == 0 {
+		return body, nil
+	}
+
+	type compiled struct {
+		selector     *selector
+		order        int
+		declarations []declaration
+	}
+	var rules []compiled
+
+	for _, style := range styles {
+		sheet := parseStylesheet(textContent(style))
+		var kept []rule
+
+		for _, r := range sheet.rules {
+			var unsupported []string
+			for _, text := range splitOutside(r.selectors, ',') {
+				sel, err := parseSelector(text)
+				if err != nil {
+					unsupported = append(unsupported, strings.TrimSpace(text))
+					continue
+				}
+				rules = append(rules, compiled{sel, len(rules), r.declarations})
+			}
+			if len(unsupported) > 0 {
+				kept = append(kept, rule{strings.Join(unsupported, ", "), r.declarations})
+			}
+		}
+
+		css := formatRules(kept)
+		for _, atRule := range sheet.atRules {
+			css += atRule + "\n"
+		}
+
+		if css == "" {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+		for child := style.FirstChild; child != nil; child = style.FirstChild {
+			style.RemoveChild(child)
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: "\n" + css})
+	}
+
+	walk(doc, func(node *html.Node) {
+		var matches []match
+		for _, r := range rules {
+			if r.selector.match(node) {
+				matches = append(matches, match{r.selector.specificity, r.order, r.declarations})
+			}
+		}
+		if len(matches) > 0 {
+			setStyle(node, matches)
+		}
+	})
+
+	var buff bytes.Buffer
+	if err := html.Render(&buff, doc); err != nil {
+		return "", err
+	}
+
+	return buff.String(), nil
+}
+
+// Filter inlines the CSS of an HTML body and can be used as a
+// message.HTMLFilter. The body is returned unchanged if it cannot be processed.
+func Filter(body, recipient string) string {
+	inlined, err := Inline(body)
+	if err != nil {
+		return body
+	}
+	return inlined
+}
+
+// setStyle merges the declarations of the matching rules with the style
+// attribute of an element. Important declarations win over the style attribute,
+// which wins over the other declarations. A property that is overridden is moved
+// at the end so shorthand properties keep their meaning.
+func setStyle(node *html.Node, matches []match) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].specificity != matches[j].specificity {
+			return matches[i].specificity < matches[j].specificity
+		}
+		return matches[i].order < matches[j].order
+	})
+
+	var style []declaration
+	set := func(decl declaration) {
+		for index, d := range style {
+			if d.property == decl.property {
+				style = append(style[:index], style[index+1:]...)
+				break
+			}
+		}
+		style = append(style, decl)
+	}
+
+	inline := parseDeclarations(getAttr(node, "style"))
+	for _, important := range []bool{false, true} {
+		for _, m := range matches {
+			for _, decl := range m.declarations {
+				if decl.important == important {
+					set(decl)
+				}
+			}
+		}
+		for _, decl := range inline {
+			if decl.important == important {
+				set(decl)
+			}
+		}
+	}
+
+	setAttr(node, "style", formatDeclarations(style))
+}
+
+//Check whether the rules of a style element apply to every media
+func isInlinable(style *html.Node) bool {
+	for _, attr := range style.Attr {
+		if attr.Key == "media" {
+			media := strings.ToLower(strings.TrimSpace(attr.Val))
+			return media == "" || media == "all" || media == "screen"
+		}
+	}
+	return true
+}
+
+//Call fn on every element of the tree rooted at node
+func walk(node *html.Node, fn func(node *html.Node)) {
+	if node.Type == html.ElementNode {
+		fn(node)
+	}
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		walk(child, fn)
+		child = next
+	}
+}
+
+//Return the text contained in a node and its children
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
+
+//Return the value of an attribute of a node
+func getAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+//Set the value of an attribute of a node
+func setAttr(node *html.Node, key, value string) {
+	for index, attr := range node.Attr {
+		if attr.Key == key {
+			node.Attr[index].Val = value
+			return
+		}
+	}
+	node.Attr = append(node.Attr, html.Attribute{Key: key, Val: value})
+}
@@ -0,0 +1,74 @@
+package inliner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	body := `<html><head><style>
+/* comment */ p { color: red; margin: 0 }
+.note { color: blue; font-family: "a;b" }
+#main p.note { color: green }
+a:hover { color: pink }
+td:first-child, th { padding: 4px !important }
+@media (max-width: 600px) { p { color: black } }
+</style></head><body><div id="main"><p class="note" style="margin-top: 5px">Hi</p><p style="color: gray">there</p>
+<table><tr><td style="padding: 1px">a</td><td>b</td></tr></table><a href="#">link</a></div></body></html>`
+
+	out, err := Inline(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		// The most specific rule wins, and the style attribute wins over it.
+		`<p class="note" style="margin: 0; font-family: &#34;a;b&#34;; color: green; margin-top: 5px">Hi</p>`,
+		`<p style="margin: 0; color: gray">there</p>`,
+		// Important declarations win over the style attribute.
+		`<td style="padding: 4px !important">a</td><td>b</td>`,
+		// The rules that cannot be inlined are kept.
+		"<style>\na:hover { color: pink }\n@media (max-width: 600px) { p { color: black } }\n</style>",
+		`<a href="#">link</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+}
+
+func TestInlineMedia(t *testing.T) {
+	out, err := Inline(`<html><head><style media="print">p { color: red }</style><style>p { color: blue }</style></head><body><p>Hi</p></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<html><head><style media="print">p { color: red }</style></head><body><p style="color: blue">Hi</p></body></html>`
+	if out != want {
+		t.Errorf("Inline() = %s, want %s", out, want)
+	}
+}
+
+func TestInlineWithoutStyle(t *testing.T) {
+	body := "<p>Hi</p>"
+	if out, err := Inline(body); err != nil || out != body {
+		t.Errorf("Inline() = %q, %v, want the body unchanged", out, err)
+	}
+}
+
+func TestInlineUnclosedRule(t *testing.T) {
+	tests := map[string]string{
+		"<style>a{</style><p>x</p>":                     "<p>x</p>",
+		"<style>p{color:red</style><p>x</p>":            `<p style="color: red">x</p>`,
+		"<style>@media print { p{color:red</style><p>x": "<p>x",
+	}
+	for body, want := range tests {
+		out, err := Inline(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("Inline(%q) = %q, want %s", body, out, want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package inliner
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ishail/m-mail/common"
+	"golang.org/x/net/html"
+)
+
+var errUnsupported = errors.New("m-mail: unsupported selector")
+
+// selector is a complex selector: a list of compound selectors separated by
+// combinators. compounds[i] is joined to compounds[i+1] by combinators[i].
+type selector struct {
+	compounds   []*compound
+	combinators []byte
+	specificity int
+}
+
+// compound is a sequence of simple selectors matching a single element.
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+	pseudos []string
+}
+
+type attrSelector struct {
+	key   string
+	op    string
+	value string
+}
+
+// supportedPseudos are the pseudo-classes that do not depend on user
+// interaction and can therefore be inlined.
+var supportedPseudos = map[string]bool{
+	"first-child":   true,
+	"last-child":    true,
+	"only-child":    true,
+	"first-of-type": true,
+	"last-of-type":  true,
+}
+
+//Parse a complex selector, returning errUnsupported for selectors that cannot
+//be inlined
+func parseSelector(text string) (*selector, error) {
+	sel := &selector{}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errUnsupported
+	}
+
+	for text != "" {
+		comp, rest, err := parseCompound(text)
+		if err != nil {
+			return nil, err
+		}
+		sel.compounds = append(sel.compounds, comp)
+
+		trimmed := strings.TrimLeft(rest, " \t\r\n\f")
+		if trimmed == "" {
+			break
+		}
+		switch trimmed[0] {
+		case '>', '+', '~':
+			sel.combinators = append(sel.combinators, trimmed[0])
+			trimmed = strings.TrimLeft(trimmed[1:], " \t\r\n\f")
+		default:
+			if len(trimmed) == len(rest) {
+				return nil, errUnsupported
+			}
+			sel.combinators = append(sel.combinators, ' ')
+		}
+		text = trimmed
+	}
+
+	if len(sel.combinators) != len(sel.compounds)-1 {
+		return nil, errUnsupported
+	}
+
+	for _, comp := range sel.compounds {
+		if comp.id != "" {
+			sel.specificity += 10000
+		}
+		sel.specificity += 100 * (len(comp.classes) + len(comp.attrs) + len(comp.pseudos))
+		if comp.tag != "" && comp.tag != "*" {
+			sel.specificity++
+		}
+	}
+
+	return sel, nil
+}
+
+//Parse a compound selector at the start of text and return the rest of text
+func parseCompound(text string) (*compound, string, error) {
+	comp := &compound{}
+	start := len(text)
+
+	if name, rest := readIdent(text); name != "" {
+		comp.tag = strings.ToLower(name)
+		text = rest
+	} else if strings.HasPrefix(text, "*") {
+		comp.tag = "*"
+		text = text[1:]
+	}
+
+	for text != "" {
+		switch text[0] {
+		case '#':
+			name, rest := readIdent(text[1:])
+			if name == "" {
+				return nil, "", errUnsupported
+			}
+			comp.id, text = name, rest
+		case '.':
+			name, rest := readIdent(text[1:])
+			if name == "" {
+				return nil, "", errUnsupported
+			}
+			comp.classes = append(comp.classes, name)
+			text = rest
+		case '[':
+			end := strings.IndexByte(text, ']')
+			if end == -1 {
+				return nil, "", errUnsupported
+			}
+			attr, err := parseAttrSelector(text[1:end])
+			if err != nil {
+				return nil, "", err
+			}
+			comp.attrs = append(comp.attrs, attr)
+			text = text[end+1:]
+		case ':':
+			name, rest := readIdent(text[1:])
+			if !supportedPseudos[strings.ToLower(name)] {
+				return nil, "", errUnsupported
+			}
+			comp.pseudos = append(comp.pseudos, strings.ToLower(name))
+			text = rest
+		case ' ', '\t', '\r', '\n', '\f', '>', '+', '~':
+			if len(text) == start {
+				return nil, "", errUnsupported
+			}
+			return comp, text, nil
+		default:
+			return nil, "", errUnsupported
+		}
+	}
+
+	return comp, text, nil
+}
+
+func parseAttrSelector(text string) (attrSelector, error) {
+	text = strings.TrimSpace(text)
+	for _, op := range []string{"~=", "|=", "^=", "$=", "*=", "="} {
+		if index := strings.Index(text, op); index != -1 {
+			value := strings.TrimSpace(text[index+len(op):])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			return attrSelector{
+				key:   strings.ToLower(strings.TrimSpace(text[:index])),
+				op:    op,
+				value: value,
+			}, nil
+		}
+	}
+
+	if name, rest := readIdent(text); name == "" || rest != "" {
+		return attrSelector{}, errUnsupported
+	}
+	return attrSelector{key: strings.ToLower(text)}, nil
+}
+
+//Read a CSS identifier at the start of text and return the rest of text
+func readIdent(text string) (string, string) {
+	end := 0
+	for end < len(text) {
+		c := text[end]
+		if c == '-' || c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') ||
+			('A' <= c && c <= 'Z') || ('0' <= c && c <= '9' && end > 0) {
+			end++
+			continue
+		}
+		break
+	}
+	return text[:end], text[end:]
+}
+
+//Check whether an element matches the selector
+func (sel *selector) match(node *html.Node) bool {
+	return sel.matchAt(node, len(sel.compounds)-1)
+}
+
+func (sel *selector) matchAt(node *html.Node, index int) bool {
+	if !sel.compounds[index].match(node) {
+		return false
+	}
+	if index == 0 {
+		return true
+	}
+
+	switch sel.combinators[index-1] {
+	case ' ':
+		for parent := node.Parent; parent != nil; parent = parent.Parent {
+			if parent.Type == html.ElementNode && sel.matchAt(parent, index-1) {
+				return true
+			}
+		}
+	case '>':
+		if parent := node.Parent; parent != nil && parent.Type == html.ElementNode {
+			return sel.matchAt(parent, index-1)
+		}
+	case '+':
+		if prev := previousElement(node); prev != nil {
+			return sel.matchAt(prev, index-1)
+		}
+	case '~':
+		for prev := previousElement(node); prev != nil; prev = previousElement(prev) {
+			if sel.matchAt(prev, index-1) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (comp *compound) match(node *html.Node) bool {
+	if node.Type != html.ElementNode {
+		return false
+	}
+	if comp.tag != "" && comp.tag != "*" && comp.tag != node.Data {
+		return false
+	}
+	if comp.id != "" && getAttr(node, "id") != comp.id {
+		return false
+	}
+
+	classes := strings.Fields(getAttr(node, "class"))
+	for _, class := range comp.classes {
+		if !common.SearchString(classes, class) {
+			return false
+		}
+	}
+
+	for _, attr := range comp.attrs {
+		if !attr.match(node) {
+			return false
+		}
+	}
+
+	for _, pseudo := range comp.pseudos {
+		if !matchPseudo(node, pseudo) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (attr attrSelector) match(node *html.Node) bool {
+	for _, a := range node.Attr {
+		if a.Key != attr.key {
+			continue
+		}
+		switch attr.op {
+		case "":
+			return true
+		case "=":
+			return a.Val == attr.value
+		case "~=":
+			return common.SearchString(strings.Fields(a.Val), attr.value)
+		case "|=":
+			return a.Val == attr.value || strings.HasPrefix(a.Val, attr.value+"-")
+		case "^=":
+			return attr.value != "" && strings.HasPrefix(a.Val, attr.value)
+		case "$=":
+			return attr.value != "" && strings.HasSuffix(a.Val, attr.value)
+		case "*=":
+			return attr.value != "" && strings.Contains(a.Val, attr.value)
+		}
+	}
+	return false
+}
+
+func matchPseudo(node *html.Node, pseudo string) bool {
+	switch pseudo {
+	case "first-child":
+		return previousElement(node) == nil
+	case "last-child":
+		return nextElement(node) == nil
+	case "only-child":
+		return previousElement(node) == nil && nextElement(node) == nil
+	case "first-of-type":
+		for prev := previousElement(node); prev != nil; prev = previousElement(prev) {
+			if prev.Data == node.Data {
+				return false
+			}
+		}
+		return true
+	case "last-of-type":
+		for next := nextElement(node); next != nil; next = nextElement(next) {
+			if next.Data == node.Data {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func previousElement(node *html.Node) *html.Node {
+	for prev := node.PrevSibling; prev != nil; prev = prev.PrevSibling {
+		if prev.Type == html.ElementNode {
+			return prev
+		}
+	}
+	return nil
+}
+
+func nextElement(node *html.Node) *html.Node {
+	for next := node.NextSibling; next != nil; next = next.NextSibling {
+		if next.Type == html.ElementNode {
+			return next
+		}
+	}
+	return nil
+}
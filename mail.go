@@ -23,8 +23,31 @@ func NewPlainDialer(host string, port int, username, password string) *sender.Di
 	return sender.NewDialer(host, port, username, password)
 }
 
+// A SendError is returned by DialAndSend when one of the messages could not be
+// sent, or was rejected for one of its recipients.
+type SendError struct {
+	// Index is the index of the message in the arguments of DialAndSend.
+	Index int
+	// Result is the outcome of each recipient of the message, if any.
+	Result *sender.Result
+	// Cause is the error returned by Send, or the error of the first
+	// recipient the message failed for, prefixed with its address.
+	Cause error
+}
+
+func (err *SendError) Error() string {
+	return fmt.Sprintf("m-mail: could not send email %d: %v", err.Index+1, err.Cause)
+}
+
+// Unwrap returns the cause of the error.
+func (err *SendError) Unwrap() error {
+	return err.Cause
+}
+
 // DialAndSend opens a connection to the SMTP server, sends the given emails and closes the
-// connection.
+// connection. It stops at the first email that cannot be sent, or that fails for one of its
+// recipients, and returns a SendError. The outcome for each recipient is reported to the
+// Logger of the dialer, if any.
 func DialAndSend(dialer *sender.Dialer, messages ...*message.Message) error {
 	sendCloser, err := dialer.Dial()
 	if err != nil {
@@ -33,10 +56,18 @@ func DialAndSend(dialer *sender.Dialer, messages ...*message.Message) error {
 	defer sendCloser.Close()
 
 	for index, msg := range messages {
-		if resp, err := sendCloser.Send(msg); err != nil {
-			fmt.Printf("m-mail: could not send email %d: %v", index+1, err)
-		} else {
-			fmt.Println("response....", resp)
+		result, err := sendCloser.Send(msg)
+		if err != nil {
+			return &SendError{Index: index, Result: result, Cause: err}
+		}
+		for _, recipient := range result.Recipients {
+			if recipient.Status == sender.Failed {
+				return &SendError{
+					Index:  index,
+					Result: result,
+					Cause:  fmt.Errorf("%s: %w", recipient.Address, recipient.Err),
+				}
+			}
 		}
 	}
 
@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/server"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+func TestDialAndSendFailedRecipient(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	srv.OnRcpt = func(to string) error {
+		if strings.HasPrefix(to, "unknown") {
+			return &server.Error{Code: 550, EnhancedCode: "5.1.1", Message: "Unknown user"}
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	first := NewMessage("Hello", "Hello there", "text/plain")
+	first.SetHeader("From", "alice@example.com")
+	first.SetHeader("To", "bob@example.com")
+	second := NewMessage("Hello", "Hello there", "text/plain")
+	second.SetHeader("From", "alice@example.com")
+	second.SetHeader("To", "carol@example.com", "unknown@example.com")
+
+	err := DialAndSend(srv.Dialer(), first, second)
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("DialAndSend returned %v, want a SendError", err)
+	}
+	if sendErr.Index != 1 || !strings.Contains(sendErr.Error(), "unknown@example.com") {
+		t.Errorf("unexpected error %v", sendErr)
+	}
+	if sent := sendErr.Result.Addresses(sender.Sent); len(sent) != 1 || sent[0] != "carol@example.com" {
+		t.Errorf("unexpected result %v", sendErr.Result)
+	}
+
+	if err := DialAndSend(srv.Dialer(), first); err != nil {
+		t.Errorf("DialAndSend returned %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package mailtest
+
+import (
+	"bytes"
+	"mime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// AssertCount checks that n messages were recorded.
+func (r *Recorder) AssertCount(t testing.TB, n int) bool {
+	t.Helper()
+
+	if count := len(r.Messages()); count != n {
+		t.Errorf("mailtest: %d messages sent, want %d", count, n)
+		return false
+	}
+	return true
+}
+
+// AssertRecipients checks that the recipients of msg, from its To, Cc and Bcc
+// headers, are the given addresses, in any order.
+func AssertRecipients(t testing.TB, msg *message.Message, addresses ...string) bool {
+	t.Helper()
+
+	to, err := msg.GetRecipients()
+	if err != nil {
+		t.Errorf("mailtest: %v", err)
+		return false
+	}
+
+	got := lowerSorted(to)
+	want := lowerSorted(addresses)
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("mailtest: recipients are %v, want %v", to, addresses)
+		return false
+	}
+	return true
+}
+
+// AssertSubject checks the subject of msg.
+func AssertSubject(t testing.TB, msg *message.Message, subject string) bool {
+	t.Helper()
+
+	if got := msg.GetSubject(); got != subject {
+		t.Errorf("mailtest: subject is %q, want %q", got, subject)
+		return false
+	}
+	return true
+}
+
+// AssertHeader checks the values of a header of msg. Encoded words are decoded
+// before the values are compared.
+func AssertHeader(t testing.TB, msg *message.Message, field string, values ...string) bool {
+	t.Helper()
+
+	decoder := &mime.WordDecoder{}
+	got := msg.GetHeader(field)
+	decoded := make([]string, len(got))
+	for index, value := range got {
+		if decoded[index], _ = decoder.DecodeHeader(value); decoded[index] == "" {
+			decoded[index] = value
+		}
+	}
+
+	if strings.Join(decoded, "\n") != strings.Join(values, "\n") || len(decoded) != len(values) {
+		t.Errorf("mailtest: header %s is %q, want %q", field, decoded, values)
+		return false
+	}
+	return true
+}
+
+// AssertPart checks that msg has a part of the given content type, its body or
+// one of its alternatives, containing text.
+func AssertPart(t testing.TB, msg *message.Message, contentType, text string) bool {
+	t.Helper()
+
+	body, bodyType := msg.GetBody()
+	contents := map[string][]string{bodyType: {body}}
+	for _, part := range msg.GetAlternatives() {
+		var buff bytes.Buffer
+		part.Copier(&buff)
+		contents[part.ContentType] = append(contents[part.ContentType], buff.String())
+	}
+
+	parts, ok := contents[contentType]
+	if !ok {
+		t.Errorf("mailtest: no %s part", contentType)
+		return false
+	}
+	for _, content := range parts {
+		if strings.Contains(content, text) {
+			return true
+		}
+	}
+	t.Errorf("mailtest: no %s part contains %q", contentType, text)
+	return false
+}
+
+// AssertAttachment checks that msg has an attached or embedded file with the
+// given name.
+func AssertAttachment(t testing.TB, msg *message.Message, name string) bool {
+	t.Helper()
+
+	var names []string
+	for _, file := range append(msg.GetAttachments(), msg.GetEmbedded()...) {
+		if file.Name == name {
+			return true
+		}
+		names = append(names, file.Name)
+	}
+	t.Errorf("mailtest: no attachment %q in %q", name, names)
+	return false
+}
+
+func lowerSorted(addresses []string) []string {
+	sorted := make([]string, len(addresses))
+	for index, addr := range addresses {
+		sorted[index] = strings.ToLower(addr)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,114 @@
+package mailtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+)
+
+// recordingT records the failures of the assertions instead of failing the
+// test.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// newReportMessage returns a message with the encoded headers, alternatives and
+// attachments checked by the assertions.
+func newReportMessage(to ...string) *message.Message {
+	msg := message.NewMessage("Réunion", "Hello there", "text/plain")
+	msg.AddAlternative("text/html", "<p>Hello there</p>")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", to...)
+	msg.SetHeader("X-Campaign", "été")
+	msg.Attach("report.pdf", message.SetCopyFunc(func(w io.Writer) error { return nil }))
+	return msg
+}
+
+func TestRecorder(t *testing.T) {
+	var _ sender.SendCloser = NewRecorder()
+
+	r := NewRecorder()
+	rejected := errors.New("mailbox full")
+	r.FailRecipient("Carol@example.com", rejected)
+
+	result, err := r.Send(newReportMessage("bob@example.com", "carol@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 1 || failed[0] != "carol@example.com" ||
+		result.Recipients[1].Err != rejected {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if _, err := r.Send(message.NewMessage("No recipient", "", "text/plain")); err == nil {
+		t.Fatal("an invalid message was recorded")
+	}
+
+	sendErr := errors.New("connection refused")
+	r.FailSend(sendErr)
+	if _, err := r.Send(newReportMessage("dave@example.com")); err != sendErr {
+		t.Fatalf("Send returned %v, want %v", err, sendErr)
+	}
+	r.FailSend(nil)
+	r.Send(newReportMessage("dave@example.com"))
+
+	r.Close()
+	if _, err := r.Send(newReportMessage("erin@example.com")); err == nil || !r.Closed() {
+		t.Fatal("a closed recorder recorded a message")
+	}
+
+	r.AssertCount(t, 2)
+	if len(r.SentTo("BOB@example.com")) != 1 || len(r.SentTo("erin@example.com")) != 0 {
+		t.Error("unexpected SentTo")
+	}
+	if to, _ := r.Last().GetRecipients(); len(to) != 1 || to[0] != "dave@example.com" {
+		t.Errorf("unexpected last message to %v", to)
+	}
+
+	r.Reset()
+	if r.Closed() || r.Last() != nil {
+		t.Fatal("Reset kept the state of the recorder")
+	}
+	if result, err := r.Send(newReportMessage("carol@example.com")); err != nil || len(result.Addresses(sender.Sent)) != 1 {
+		t.Fatalf("Reset kept the failures: %v, %v", result, err)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	msg := newReportMessage("bob@example.com", "Carol@example.com")
+
+	AssertRecipients(t, msg, "carol@example.com", "bob@example.com")
+	AssertSubject(t, msg, "Réunion")
+	AssertHeader(t, msg, "X-Campaign", "été")
+	AssertPart(t, msg, "text/plain", "Hello")
+	AssertPart(t, msg, "text/html", "<p>Hello")
+	AssertAttachment(t, msg, "report.pdf")
+
+	failing := &recordingT{}
+	for name, ok := range map[string]bool{
+		"recipients": AssertRecipients(failing, msg, "bob@example.com"),
+		"subject":    AssertSubject(failing, msg, "Meeting"),
+		"header":     AssertHeader(failing, msg, "X-Campaign", "été", "hiver"),
+		"part type":  AssertPart(failing, msg, "text/calendar", "Hello"),
+		"part text":  AssertPart(failing, msg, "text/html", "Goodbye"),
+		"attachment": AssertAttachment(failing, msg, "invoice.pdf"),
+		"count":      NewRecorder().AssertCount(failing, 1),
+	} {
+		if ok {
+			t.Errorf("%s: the assertion succeeded", name)
+		}
+	}
+	if len(failing.errors) != 7 {
+		t.Errorf("got %d failures, want 7: %q", len(failing.errors), failing.errors)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+	Package mailtest provides a Sender recording the messages sent by an
+	application, and helpers to assert their content in its tests.
+*/
+package mailtest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+)
+
+var errClosed = errors.New("m-mail: recorder is closed")
+
+// A Recorder is a sender.SendCloser recording the messages instead of sending
+// them. It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*message.Message
+	failures map[string]error
+	sendErr  error
+	closed   bool
+}
+
+// NewRecorder returns a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{failures: make(map[string]error)}
+}
+
+// Send records the message. The recipients whose failure was set with
+// FailRecipient are reported as failed, the other ones as sent. The message is
+// not recorded when Send fails, after FailSend or Close for instance.
+func (r *Recorder) Send(msg *message.Message) (*sender.Result, error) {
+	if _, err := msg.GetFrom(); err != nil {
+		return nil, err
+	}
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, errClosed
+	}
+	if r.sendErr != nil {
+		return nil, r.sendErr
+	}
+
+	result := &sender.Result{}
+	for _, addr := range to {
+		recipient := sender.RecipientResult{Address: addr, Status: sender.Sent}
+		if err, ok := r.failures[strings.ToLower(addr)]; ok {
+			recipient.Status, recipient.Err = sender.Failed, err
+		}
+		result.Recipients = append(result.Recipients, recipient)
+	}
+
+	r.messages = append(r.messages, msg)
+	return result, nil
+}
+
+// Close closes the recorder, which then fails to send messages. The recorded
+// messages are kept.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return nil
+}
+
+// Closed reports whether Close was called.
+func (r *Recorder) Closed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// FailRecipient makes Send report the recipient as failed with err.
+func (r *Recorder) FailRecipient(address string, err error) {
+	r.mu.Lock()
+	r.failures[strings.ToLower(address)] = err
+	r.mu.Unlock()
+}
+
+// FailSend makes Send fail with err, or succeed again when err is nil.
+func (r *Recorder) FailSend(err error) {
+	r.mu.Lock()
+	r.sendErr = err
+	r.mu.Unlock()
+}
+
+// Messages returns the recorded messages, in the order they were sent.
+func (r *Recorder) Messages() []*message.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*message.Message(nil), r.messages...)
+}
+
+// Last returns the last recorded message, or nil.
+func (r *Recorder) Last() *message.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.messages) == 0 {
+		return nil
+	}
+	return r.messages[len(r.messages)-1]
+}
+
+// SentTo returns the recorded messages with the given address among their
+// recipients.
+func (r *Recorder) SentTo(address string) []*message.Message {
+	var messages []*message.Message
+	for _, msg := range r.Messages() {
+		to, _ := msg.GetRecipients()
+		for _, addr := range to {
+			if strings.EqualFold(addr, address) {
+				messages = append(messages, msg)
+				break
+			}
+		}
+	}
+	return messages
+}
+
+// Reset forgets the recorded messages and the simulated failures, and reopens
+// the recorder.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.messages = nil
+	r.failures = make(map[string]error)
+	r.sendErr = nil
+	r.closed = false
+	r.mu.Unlock()
+}
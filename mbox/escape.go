@@ -0,0 +1,85 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+)
+
+var fromLine = []byte("From ")
+
+// escaper converts the line endings of a message to LF and escapes its "From "
+// lines as defined by mboxrd.
+type escaper struct {
+	w *bufio.Writer
+	// prefix is the start of the current line while it may be a "From " line.
+	prefix []byte
+	// inLine is true once the current line is known not to be a "From " line.
+	inLine bool
+	cr     bool
+	last   byte
+}
+
+func (e *escaper) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if e.cr {
+			e.cr = false
+			if c != '\n' {
+				e.writeByte('\r')
+			}
+		}
+		if c == '\r' {
+			e.cr = true
+			continue
+		}
+		e.writeByte(c)
+	}
+
+	return len(p), nil
+}
+
+func (e *escaper) writeByte(c byte) {
+	if e.inLine {
+		e.put(c)
+		e.inLine = c != '\n'
+		return
+	}
+
+	e.prefix = append(e.prefix, c)
+	rest := bytes.TrimLeft(e.prefix, ">")
+	if bytes.HasPrefix(fromLine, rest) {
+		if len(rest) == len(fromLine) {
+			e.put('>')
+			e.flushPrefix()
+			e.inLine = true
+		}
+		return
+	}
+
+	e.flushPrefix()
+	e.inLine = c != '\n'
+}
+
+//Write the pending start of line
+func (e *escaper) flushPrefix() {
+	for _, c := range e.prefix {
+		e.put(c)
+	}
+	e.prefix = e.prefix[:0]
+}
+
+func (e *escaper) put(c byte) {
+	e.w.WriteByte(c)
+	e.last = c
+}
+
+//Write what is pending and terminate the message with an empty line
+func (e *escaper) close() {
+	if e.cr {
+		e.put('\r')
+	}
+	e.flushPrefix()
+	if e.last != '\n' {
+		e.put('\n')
+	}
+	e.put('\n')
+}
@@ -0,0 +1,105 @@
+/*
+	Package mbox appends messages to mailbox files in the mboxrd format, which
+	can be read by most mail clients.
+*/
+package mbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Writer appends messages to an mbox. It is safe for concurrent use.
+type Writer struct {
+	mu  sync.Mutex
+	out io.Writer
+	w   *bufio.Writer
+}
+
+// A File is a Writer appending to a file, which should be closed when done
+// using it.
+type File struct {
+	*Writer
+	file *os.File
+}
+
+// NewWriter returns a Writer appending messages to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{out: w, w: bufio.NewWriter(w)}
+}
+
+// Open opens the mbox file at path for appending, creating it if it does not
+// exist.
+func Open(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{Writer: NewWriter(file), file: file}, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// WriteMessage appends a message with the given envelope sender and delivery
+// date. The message is written with LF line endings and the lines starting with
+// "From ", preceded by any number of ">", are escaped with an additional ">".
+// When msg fails to write, what is still buffered is discarded but the start of
+// a large message may already have been written. File.WriteMessage removes it.
+func (w *Writer) WriteMessage(from string, date time.Time, msg io.WriterTo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writeMessage(from, date, msg)
+}
+
+// WriteMessage appends a message like Writer.WriteMessage. When the message
+// fails to write, the file is truncated back to its previous size so that it
+// does not end with a partial message.
+func (f *File) WriteMessage(from string, date time.Time, msg io.WriterTo) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := f.writeMessage(from, date, msg); err != nil {
+		if truncErr := f.file.Truncate(info.Size()); truncErr != nil {
+			return fmt.Errorf("%v (and the partial message could not be removed: %v)", err, truncErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//Write a message, the caller must hold the lock
+func (w *Writer) writeMessage(from string, date time.Time, msg io.WriterTo) error {
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+
+	w.w.WriteString("From " + from + " " + date.UTC().Format(time.ANSIC) + "\n")
+
+	esc := &escaper{w: w.w, last: '\n'}
+	if _, err := msg.WriteTo(esc); err != nil {
+		w.w.Reset(w.out)
+		return err
+	}
+	esc.close()
+
+	if err := w.w.Flush(); err != nil {
+		w.w.Reset(w.out)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type rawMessage string
+
+func (msg rawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(msg))
+	return int64(n), err
+}
+
+// failingMessage writes its content then fails.
+type failingMessage struct {
+	content string
+	err     error
+}
+
+func (msg failingMessage) WriteTo(w io.Writer) (int64, error) {
+	n, _ := io.WriteString(w, msg.content)
+	return int64(n), msg.err
+}
+
+var date = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	if err := w.WriteMessage("alice@example.com", date, rawMessage(
+		"Subject: x\r\n\r\nFrom here\r\n>From there\r\nFro\r\n>>From \r\nx\rFrom y\r\nFrom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessage("", date, rawMessage("Subject: y\n\nbody\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "From alice@example.com Thu Jan  2 03:04:05 2020\n" +
+		"Subject: x\n\n>From here\n>>From there\nFro\n>>>From \nx\rFrom y\nFrom\n\n" +
+		"From MAILER-DAEMON Thu Jan  2 03:04:05 2020\n" +
+		"Subject: y\n\nbody\n\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFileRemovesPartialMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	file, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := file.WriteMessage("alice@example.com", date, rawMessage("Subject: first\n\nbody\n")); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The content is larger than the buffer, so part of it reaches the file
+	// before the message fails.
+	writeErr := errors.New("disk failure")
+	err = file.WriteMessage("alice@example.com", date, failingMessage{
+		content: "Subject: second\n\n" + strings.Repeat("x", 64*1024),
+		err:     writeErr,
+	})
+	if err != writeErr {
+		t.Fatalf("WriteMessage returned %v, want %v", err, writeErr)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Fatalf("the partial message was left in the file: %d bytes, want %d", len(after), len(before))
+	}
+
+	if err := file.WriteMessage("alice@example.com", date, rawMessage("Subject: third\n\nbody\n")); err != nil {
+		t.Fatal(err)
+	}
+	after, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string(before) + "From alice@example.com Thu Jan  2 03:04:05 2020\nSubject: third\n\nbody\n\n"; string(after) != want {
+		t.Errorf("got %q, want %q", after, want)
+	}
+}
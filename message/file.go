@@ -0,0 +1,145 @@
+package message
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/ishail/m-mail/common"
+)
+
+// Rename is a file setting to set the name of the attachment if the name is
+// different than the filename on disk.
+func Rename(name string) FileSetting {
+	return func(file *common.File) {
+		file.Name = name
+	}
+}
+
+// SetFileHeader is a file setting to set the MIME headers of the attachment,
+// such as its Content-Type.
+func SetFileHeader(header common.Header) FileSetting {
+	return func(file *common.File) {
+		for key, value := range header {
+			file.Header[key] = value
+		}
+	}
+}
+
+// SetCopyFunc is a file setting to replace the function that copies the content
+// of the file to the email. It can be used to attach content that is not on
+// disk.
+func SetCopyFunc(copyFunc func(io.Writer) error) FileSetting {
+	return func(file *common.File) {
+		file.CopyFunc = copyFunc
+	}
+}
+
+// Attach attaches the file to the email.
+func (msg *Message) Attach(filename string, settings ...FileSetting) {
+	msg.attachments = append(msg.attachments, newFile(filename, settings))
+}
+
+// Embed embeds the image in the email. It can be referenced in the HTML body
+// with its name: <img src="cid:image.jpg">.
+func (msg *Message) Embed(filename string, settings ...FileSetting) {
+	msg.embedded = append(msg.embedded, newFile(filename, settings))
+}
+
+// GetAttachments gets the files attached to the message.
+func (msg *Message) GetAttachments() []*common.File {
+	return msg.attachments
+}
+
+// GetEmbedded gets the files embedded in the message.
+func (msg *Message) GetEmbedded() []*common.File {
+	return msg.embedded
+}
+
+func newFile(filename string, settings []FileSetting) *common.File {
+	file := &common.File{
+		Name:   filepath.Base(filename),
+		Header: make(common.Header),
+		CopyFunc: func(w io.Writer) error {
+			h, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, h); err != nil {
+				h.Close()
+				return err
+			}
+			return h.Close()
+		},
+	}
+
+	for _, setting := range settings {
+		setting(file)
+	}
+
+	return file
+}
+
+//Returns a Copier writing the given content
+func bytesCopier(content []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	}
+}
+
+//Write the headers and the base64 encoded content of a file
+func writeFile(w io.Writer, file *common.File, isAttachment bool) error {
+	header := make(common.Header)
+	for key, value := range file.Header {
+		header[key] = value
+	}
+
+	if _, ok := header["Content-Type"]; !ok {
+		mediaType := mime.TypeByExtension(filepath.Ext(file.Name))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		header["Content-Type"] = []string{mime.FormatMediaType(mediaType, map[string]string{"name": file.Name})}
+	}
+
+	if _, ok := header["Content-Disposition"]; !ok {
+		disposition := "inline"
+		if isAttachment {
+			disposition = "attachment"
+		}
+		header["Content-Disposition"] = []string{mime.FormatMediaType(disposition, map[string]string{"filename": file.Name})}
+	}
+
+	if _, ok := header["Content-ID"]; !ok && !isAttachment {
+		header["Content-ID"] = []string{"<" + file.Name + ">"}
+	}
+
+	header["Content-Transfer-Encoding"] = []string{string(common.Base64)}
+
+	w.Write(headerBytes(header))
+	io.WriteString(w, "\r\n")
+
+	enc := newEncoder(w, common.Base64)
+	if err := file.CopyFunc(enc); err != nil {
+		return fmt.Errorf("m-mail: unable to copy file %s: %v", file.Name, err)
+	}
+	return enc.Close()
+}
+
+// WriteEML writes the message to the file at path in the .eml format, as it is
+// sent to all of its recipients.
+func (msg *Message) WriteEML(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := msg.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,49 @@
+package message
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteEML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.eml")
+
+	msg := NewMessage("Hello", "Hello there", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+	if err := msg.WriteEML(path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	parsed, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Subject") != "Hello" || parsed.Header.Get("To") != "bob@example.com" {
+		t.Errorf("unexpected header %v", parsed.Header)
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "\r\nHello there\r\n") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestWriteEMLError(t *testing.T) {
+	msg := NewMessage("Hello", "Hello there", "text/plain")
+	if err := msg.WriteEML(filepath.Join(t.TempDir(), "missing", "hello.eml")); err == nil {
+		t.Fatal("WriteEML succeeded in a missing directory")
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"mime/quotedprintable"
+	"html"
+	"io"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ishail/m-mail/common"
@@ -46,10 +49,40 @@ func (msg *Message) ApplySettings(settings []MessageSetting) {
 	}
 }
 
+// AddAlternative adds an alternative part to the message. It is commonly used to
+// send HTML emails that default to the plain text version for backward
+// compatibility. The body of the message set in NewMessage is always the first
+// part.
+func (msg *Message) AddAlternative(contentType, body string) {
+	msg.parts = append(msg.parts, &common.Part{
+		ContentType: contentType,
+		Copier:      stringCopier(body),
+		Encoding:    string(msg.encoding),
+	})
+}
+
+// AddHTMLFilter adds a filter applied to the HTML parts of the message every
+// time it is rendered. Filters are applied in the order they were added.
+func (msg *Message) AddHTMLFilter(filter HTMLFilter) {
+	msg.htmlFilters = append(msg.htmlFilters, filter)
+}
+
 func (msg *Message) AddTrackingUrl(url string) {
 	msg.trackingUrl = url
 }
 
+// AddHeaderFunc adds a function returning headers specific to each recipient.
+// They are written after the headers of the message.
+func (msg *Message) AddHeaderFunc(headerFunc func(recipient string) common.Header) {
+	msg.headerFuncs = append(msg.headerFuncs, headerFunc)
+}
+
+// AddTrackingUrlFunc sets a function returning the url of the tracking pixel of
+// each recipient. It takes precedence over the url set with AddTrackingUrl.
+func (msg *Message) AddTrackingUrlFunc(urlFunc func(recipient string) string) {
+	msg.trackingUrlFunc = urlFunc
+}
+
 // Reset resets the message so it can be reused. The message keeps its previous
 // settings so it is in the same state that after a call to NewMessage.
 func (msg *Message) Reset() {
@@ -59,6 +92,7 @@ func (msg *Message) Reset() {
 	msg.parts = nil
 	msg.attachments = nil
 	msg.embedded = nil
+	msg.recipients = nil
 }
 
 func (msg *Message) SetHeader(field string, value ...string) {
@@ -94,29 +128,28 @@ func (msg *Message) FormatAddress(address, name string) string {
 		return address
 	}
 
+	var addr strings.Builder
 	enc := msg.encodeString(name)
 	if enc == name {
-		msg.buff.WriteByte('"')
+		addr.WriteByte('"')
 		for _, character := range name {
 			if character == '\\' || character == '"' {
-				msg.buff.WriteByte('\\')
+				addr.WriteByte('\\')
 			}
-			msg.buff.WriteByte(byte(character))
+			addr.WriteRune(character)
 		}
-		msg.buff.WriteByte('"')
+		addr.WriteByte('"')
 	} else if common.HasSpecials(name) {
-		msg.buff.WriteString(common.BEncoding.Encode(msg.charset, name))
+		addr.WriteString(common.BEncoding.Encode(msg.charset, name))
 	} else {
-		msg.buff.WriteString(enc)
+		addr.WriteString(enc)
 	}
 
-	msg.buff.WriteString(" <")
-	msg.buff.WriteString(address)
-	msg.buff.WriteByte('>')
+	addr.WriteString(" <")
+	addr.WriteString(address)
+	addr.WriteByte('>')
 
-	addr := msg.buff.String()
-	msg.buff.Reset()
-	return addr
+	return addr.String()
 }
 
 // SetDateHeader sets a date to the given header field.
@@ -124,11 +157,66 @@ func (msg *Message) SetDateHeader(field string, date time.Time) {
 	msg.header[field] = []string{common.FormatDate(date)}
 }
 
+// MessageID returns the Message-Id header of the message. A unique one is
+// generated and set if the message has none. Since it modifies the message, it
+// must be called before the message is rendered concurrently: TrackClicks and
+// TrackOpens call it when they are applied, so rendering only reads it.
+func (msg *Message) MessageID() string {
+	msg.idMu.Lock()
+	defer msg.idMu.Unlock()
+
+	for _, field := range []string{"Message-Id", "Message-ID"} {
+		if id := msg.header[field]; len(id) > 0 {
+			return id[0]
+		}
+	}
+
+	id := generateMessageID()
+	msg.header["Message-Id"] = []string{id}
+	return id
+}
+
 // GetHeader gets a header field.
 func (msg *Message) GetHeader(field string) []string {
 	return msg.header[field]
 }
 
+// SetSubject sets the subject of the message.
+func (msg *Message) SetSubject(subject string) {
+	msg.subject = subject
+}
+
+// GetSubject gets the subject of the message.
+func (msg *Message) GetSubject() string {
+	return msg.subject
+}
+
+// SetBody sets the body of the message and its type, "text/plain" or
+// "text/html", as in NewMessage.
+func (msg *Message) SetBody(body, emailType string) {
+	msg.body = body
+	if emailType == "html" || emailType == "text/html" {
+		msg.emailType = "text/html"
+	} else {
+		msg.emailType = "text/plain"
+	}
+}
+
+// GetBody gets the body of the message and its content type.
+func (msg *Message) GetBody() (string, string) {
+	return msg.body, msg.emailType
+}
+
+// GetAlternatives gets the parts added with AddAlternative.
+func (msg *Message) GetAlternatives() []*common.Part {
+	return msg.parts
+}
+
+// GetCategory gets the category of the message.
+func (msg *Message) GetCategory() string {
+	return msg.category
+}
+
 //Get From address from Message model
 func (msg *Message) GetFrom() (string, error) {
 	if from, ok := msg.header["From"]; ok {
@@ -169,79 +257,167 @@ func (msg *Message) GetRecipients() ([]string, error) {
 	return recipients, nil
 }
 
-//Convert Message object into bytes
+// WriteTo implements io.WriterTo. It writes the message as it is sent to all of
+// its recipients, without the personalization of any of them.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	return msg.WriteToRecipient(w, "")
+}
+
+// WriteToRecipient writes the message as it is rendered for the given recipient.
+// The parts and files of the message are encoded while they are copied to w, so
+// attachments are never held in memory.
+func (msg *Message) WriteToRecipient(w io.Writer, to string) (int64, error) {
+	cw := &countWriter{w: w}
+
+	cw.WriteString("Mime-Version: 1.0\r\n")
+	if to == "" {
+		if addresses, ok := msg.header["To"]; ok {
+			cw.Write(getHeaderBytes("To", addresses...))
+		}
+	} else if recipient := msg.getRecipient(to); recipient != nil && recipient.Name != "" {
+		cw.WriteString("To: " + msg.FormatAddress(to, recipient.Name) + "\r\n")
+	} else {
+		cw.WriteString("To: " + to + "\r\n")
+	}
+	if _, ok := msg.header["Date"]; !ok {
+		cw.WriteString("Date: " + common.FormatDate(time.Now()) + "\r\n")
+	}
+	subject, err := msg.personalizeHeader(msg.subject, to)
+	if err != nil {
+		return cw.n, err
+	}
+	headers, err := msg.getHeadersBytes(to)
+	if err != nil {
+		return cw.n, err
+	}
+	parts, err := msg.alternativeParts(to)
+	if err != nil {
+		return cw.n, err
+	}
+
+	cw.WriteString("Subject: " + msg.encodeString(subject) + "\r\n")
+	cw.Write(headers)
+	if to != "" {
+		for _, headerFunc := range msg.headerFuncs {
+			cw.Write(headerBytes(headerFunc(to)))
+		}
+	}
+
+	if msg.hasMixedPart() {
+		cw.Write(openMultipart("mixed", mixedBoundary))
+	}
+	if msg.hasRelatedPart() {
+		cw.Write(openMultipart("related", relatedBoundary))
+	}
+
+	cw.WriteString("Content-Type: multipart/alternative; boundary=" + alternativeBoundary + "\r\n\r\n")
+	for _, part := range parts {
+		cw.WriteString("--" + alternativeBoundary + "\r\n")
+		if err := writePart(cw, part, msg.charset); err != nil {
+			return cw.n, err
+		}
+	}
+	cw.WriteString("--" + alternativeBoundary + "--\r\n")
+
+	if msg.hasRelatedPart() {
+		for _, file := range msg.embedded {
+			cw.WriteString("\r\n--" + relatedBoundary + "\r\n")
+			if err := writeFile(cw, file, false); err != nil {
+				return cw.n, err
+			}
+		}
+		cw.WriteString("\r\n--" + relatedBoundary + "--\r\n")
+	}
+
+	if msg.hasMixedPart() {
+		for _, file := range msg.attachments {
+			cw.WriteString("\r\n--" + mixedBoundary + "\r\n")
+			if err := writeFile(cw, file, true); err != nil {
+				return cw.n, err
+			}
+		}
+		cw.WriteString("\r\n--" + mixedBoundary + "--\r\n")
+	}
+
+	return cw.n, cw.err
+}
+
+// GetEmailBytes returns the message as it is rendered for the given recipient.
+// Use WriteToRecipient to avoid holding the whole message in memory.
 func (msg *Message) GetEmailBytes(to string) []byte {
 	var msgBytes bytes.Buffer
+	msg.WriteToRecipient(&msgBytes, to)
+	return msgBytes.Bytes()
+}
 
-	msgBytes.WriteString("Mime-Version: 1.0\r\n")
-	msgBytes.WriteString("To: " + to + "\r\n")
-	msgBytes.WriteString("Date: " + time.Now().String() + "\r\n")
-	msgBytes.WriteString("Subject: " + msg.subject + "\r\n")
-	msgBytes.WriteString("Content-Type: multipart/alternative; boundary=boundary-type-1234567892-alt\r\n\r\n")
-	msgBytes.WriteString("--boundary-type-1234567892-alt\r\n")
-	msgBytes.WriteString("Content-Type: " + msg.emailType + `; charset=` + msg.charset + "\r\n")
-	w := quotedprintable.NewWriter(&msgBytes)
+// alternativeParts returns the body of the message followed by its alternatives,
+// in the order they are written in the multipart/alternative section for the
+// given recipient. The HTML filters of the message are applied to every HTML
+// part. When a tracking url is set, the pixel is appended to every HTML part, or
+// to an HTML copy of the body if the message has none.
+func (msg *Message) alternativeParts(to string) ([]*common.Part, error) {
+	text, err := msg.personalize(msg.body, to, msg.emailType == "text/html")
+	if err != nil {
+		return nil, err
+	}
+	body := &common.Part{
+		ContentType: msg.emailType,
+		Copier:      stringCopier(text),
+	}
 	if msg.emailType == "text/html" {
-		msgBytes.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
-		w.Write([]byte(msg.body))
-		w.Close()
-	} else {
-		msgBytes.WriteString(msg.body + "\r\n")
+		body.Encoding = string(common.QuotedPrintable)
+	}
+	parts := []*common.Part{body}
+	for _, part := range msg.parts {
+		if msg.getRecipient(to) != nil {
+			if part, err = msg.personalizePart(part, to); err != nil {
+				return nil, err
+			}
+		}
+		parts = append(parts, part)
 	}
 
-	if msg.trackingUrl != "" {
-		msgBytes.WriteString("\r\n\r\n")
+	if msg.textAlternative {
+		parts = msg.addTextPart(parts)
+	}
 
-		if msg.emailType == "text/plain" {
-			msgBytes.WriteString("--boundary-type-1234567892-alt\r\n")
-			msgBytes.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-			msgBytes.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
-			w.Write([]byte(`<div dir="ltr">` + msg.body))
-		} else {
-			w.Write([]byte(`<div>`))
+	if len(msg.htmlFilters) > 0 {
+		for index, part := range parts {
+			if part.ContentType == "text/html" {
+				parts[index] = msg.filterPart(part, to)
+			}
 		}
+	}
 
-		w.Write([]byte(`<img src="` + msg.trackingUrl + `" style="display:none!important" height="1" width="1"></div>`))
-		w.Close()
-	}
-
-	// if _, ok := msg.header["Mime-Version"]; !ok {
-	// 	msgBytes.WriteString("Mime-Version: 1.0\r\n")
-	// }
-	// if _, ok := msg.header["Date"]; !ok {
-	// 	msgBytes.Write(writeHeader("Date", common.FormatDate(time.Now())))
-	// }
-	// msgBytes.Write(writeHeaders(msg.header))
-
-	// if msg.hasMixedPart() {
-	// 	msgBytes.Write(openMultipart("mixed"))
-	// }
-
-	// if msg.hasRelatedPart() {
-	// 	msgBytes.Write(openMultipart("related"))
-	// }
-
-	// if msg.hasAlternativePart() {
-	// 	msgBytes.Write(openMultipart("alternative"))
-	// }
-	// for _, part := range msg.parts {
-	// 	msgBytes.Write(writePart(part, msg.charset))
-	// }
-	// if msg.hasAlternativePart() {
-	// 	msgBytes.closeMultipart()
-	// }
-
-	// msgBytes.addFiles(msg.embedded, false)
-	// if msg.hasRelatedPart() {
-	// 	msgBytes.closeMultipart()
-	// }
-
-	// msgBytes.addFiles(msg.attachments, true)
-	// if msg.hasMixedPart() {
-	// 	msgBytes.closeMultipart()
-	// }
+	trackingUrl := msg.trackingUrl
+	if msg.trackingUrlFunc != nil && to != "" {
+		trackingUrl = msg.trackingUrlFunc(to)
+	}
+	if trackingUrl == "" {
+		return parts, nil
+	}
 
-	return msgBytes.Bytes()
+	pixel := `<img src="` + html.EscapeString(trackingUrl) + `" style="display:none!important" height="1" width="1"></div>`
+	hasHTML := false
+	for index, part := range parts {
+		if part.ContentType == "text/html" {
+			hasHTML = true
+			parts[index] = &common.Part{
+				ContentType: part.ContentType,
+				Copier:      appendCopier(part.Copier, `<div>`+pixel),
+				Encoding:    part.Encoding,
+			}
+		}
+	}
+	if !hasHTML {
+		parts = append(parts, &common.Part{
+			ContentType: "text/html",
+			Copier:      stringCopier(`<div dir="ltr">` + html.EscapeString(text) + pixel),
+			Encoding:    string(common.QuotedPrintable),
+		})
+	}
+
+	return parts, nil
 }
 
 func writeHeaders(header common.Header) []byte {
@@ -335,45 +511,152 @@ func writeHeader(key string, value ...string) []byte {
 // 	return ""
 // }
 
-//Returns headers of message as RFC format
-func (msg *Message) getHeadersBytes() []byte {
+//Returns headers of message as RFC format for the given recipient, except the
+//ones written for each recipient and Bcc which must not be sent
+func (msg *Message) getHeadersBytes(to string) ([]byte, error) {
+	header := make(common.Header, len(msg.header))
+	for key, values := range msg.header {
+		switch key {
+		case "To", "Bcc", "Subject", "Mime-Version", "Content-Type":
+		default:
+			header[key] = make([]string, len(values))
+			for index, value := range values {
+				var err error
+				if header[key][index], err = msg.personalizeHeaderField(key, value, to); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return headerBytes(header), nil
+}
+
+//Returns the given headers as RFC format, sorted by name
+func headerBytes(header common.Header) []byte {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	var headers bytes.Buffer
-	for key, value := range msg.header {
-		headers.Write(getHeaderBytes(key, value...))
+	for _, key := range keys {
+		headers.Write(getHeaderBytes(key, header[key]...))
 	}
 
 	return headers.Bytes()
 }
 
 func (msg *Message) hasMixedPart() bool {
-	return len(msg.attachments) > 1 || (len(msg.parts) > 0 && len(msg.attachments) > 0)
+	return len(msg.attachments) > 0
 }
 
 func (msg *Message) hasRelatedPart() bool {
-	return len(msg.attachments) > 1 || (len(msg.parts) > 0 && len(msg.embedded) > 0)
+	return len(msg.embedded) > 0
 }
 
-func (msg *Message) hasAlternativePart() bool {
-	return len(msg.parts) > 1
-}
-
-func openMultipart(mimeType string) []byte {
+//Returns the header opening a multipart section and its first boundary
+func openMultipart(mimeType, boundary string) []byte {
 	var buff bytes.Buffer
-	contentType := "multipart/" + mimeType + ";\r\n boundary=boundary-type-1234567892-alt"
+	contentType := "multipart/" + mimeType + "; boundary=" + boundary
 
-	buff.Write(writeHeader("Content-Type", contentType))
-	buff.WriteString("\r\n")
+	buff.Write(getHeaderBytes("Content-Type", contentType))
+	buff.WriteString("\r\n--" + boundary + "\r\n")
 	return buff.Bytes()
 }
 
-// func writePart(msg, contentType, charset string) []byte {
-// 	var buff bytes.Buffer
-// 	buff.Write(
-// 		writeHeaders(map[string][]string{
-// 			"Content-Type":              {contentType + "; charset=" + charset},
-// 			"Content-Transfer-Encoding": {string(p.encoding)},
-// 		}))
-// 	w.writeBody(p.copier, p.encoding)
-
-// 	return buff.Bytes()
-// }
+//Return a copy of a part personalized for the given recipient
+func (msg *Message) personalizePart(part *common.Part, to string) (*common.Part, error) {
+	var body bytes.Buffer
+	if err := part.Copier(&body); err != nil {
+		return nil, err
+	}
+
+	text, err := msg.personalize(body.String(), to, part.ContentType == "text/html")
+	if err != nil {
+		return nil, err
+	}
+	return &common.Part{
+		ContentType: part.ContentType,
+		Copier:      stringCopier(text),
+		Encoding:    part.Encoding,
+	}, nil
+}
+
+//Return a copy of an HTML part with the HTML filters of the message applied
+func (msg *Message) filterPart(part *common.Part, to string) *common.Part {
+	var body bytes.Buffer
+	if err := part.Copier(&body); err != nil {
+		return part
+	}
+
+	html := body.String()
+	for _, filter := range msg.htmlFilters {
+		html = filter(html, to)
+	}
+
+	return &common.Part{
+		ContentType: part.ContentType,
+		Copier:      stringCopier(html),
+		Encoding:    part.Encoding,
+	}
+}
+
+//Prepend a plain text version of the first HTML part, if there is no text part
+func (msg *Message) addTextPart(parts []*common.Part) []*common.Part {
+	var htmlPart *common.Part
+	for _, part := range parts {
+		switch part.ContentType {
+		case "text/plain":
+			return parts
+		case "text/html":
+			if htmlPart == nil {
+				htmlPart = part
+			}
+		}
+	}
+	if htmlPart == nil {
+		return parts
+	}
+
+	var body bytes.Buffer
+	if err := htmlPart.Copier(&body); err != nil {
+		return parts
+	}
+
+	text := &common.Part{
+		ContentType: "text/plain",
+		Copier:      stringCopier(HTMLToText(body.String())),
+		Encoding:    string(msg.encoding),
+	}
+	return append([]*common.Part{text}, parts...)
+}
+
+//Write the headers and the encoded content of a part
+func writePart(w io.Writer, part *common.Part, charset string) error {
+	io.WriteString(w, "Content-Type: "+part.ContentType+"; charset="+charset+"\r\n")
+
+	switch common.Encoding(part.Encoding) {
+	case common.QuotedPrintable, common.Base64:
+		io.WriteString(w, "Content-Transfer-Encoding: "+part.Encoding+"\r\n\r\n")
+		enc := newEncoder(w, common.Encoding(part.Encoding))
+		if err := part.Copier(enc); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	default:
+		if part.Encoding != "" {
+			io.WriteString(w, "Content-Transfer-Encoding: "+part.Encoding+"\r\n")
+		}
+		io.WriteString(w, "\r\n")
+		if err := part.Copier(w); err != nil {
+			return err
+		}
+		io.WriteString(w, "\r\n")
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
@@ -1,7 +1,7 @@
 package message
 
 import (
-	"bytes"
+	"sync"
 
 	"github.com/ishail/m-mail/common"
 )
@@ -18,9 +18,28 @@ type Message struct {
 	charset     string
 	encoding    common.Encoding
 	hEncoder    common.MimeEncoder
-	buff        bytes.Buffer
 	trackingUrl string
+	category    string
+
+	trackingUrlFunc func(recipient string) string
+
+	textAlternative bool
+	htmlFilters     []HTMLFilter
+	headerFuncs     []func(recipient string) common.Header
+
+	recipients  map[string]*Recipient
+	templates   map[string]interface{}
+	templatesMu sync.Mutex
+	idMu        sync.Mutex
 }
 
 // A MessageSetting can be used as an argument in NewMessage to configure an email.
 type MessageSetting func(m *Message)
+
+// A FileSetting can be used as an argument in Message.Attach or Message.Embed.
+type FileSetting func(*common.File)
+
+// An HTMLFilter rewrites the HTML body of a message before it is written for the
+// given recipient. A filter that cannot process the HTML should return it
+// unchanged.
+type HTMLFilter func(html, recipient string) string
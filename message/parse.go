@@ -0,0 +1,228 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/ishail/m-mail/common"
+	"golang.org/x/net/html/charset"
+)
+
+// addressHeaders are the headers holding address lists.
+var addressHeaders = []string{"From", "To", "Cc", "Bcc", "Reply-To", "Sender"}
+
+// mimeHeaders are the headers describing the MIME structure of a message, which
+// is rebuilt when the message is rendered.
+var mimeHeaders = []string{"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "Subject"}
+
+// parser holds the state of Parse.
+type parser struct {
+	msg     *Message
+	decoder *mime.WordDecoder
+	hasBody bool
+}
+
+// Parse reads an RFC 5322 message and returns it as a Message. Encoded words in
+// the headers are decoded, multipart bodies are walked recursively and the
+// content of every part is decoded and converted to UTF-8. The first text part
+// becomes the body of the message, the other parts of a multipart/alternative
+// section become its alternatives, inline parts of a multipart/related section
+// are embedded and the other parts are attached.
+func Parse(r io.Reader) (*Message, error) {
+	raw, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("m-mail: unable to parse message: %v", err)
+	}
+
+	p := &parser{
+		msg:     NewMessage("", "", "text/plain"),
+		decoder: &mime.WordDecoder{CharsetReader: charset.NewReaderLabel},
+	}
+
+	subject, err := p.decoder.DecodeHeader(raw.Header.Get("Subject"))
+	if err != nil {
+		subject = raw.Header.Get("Subject")
+	}
+	p.msg.subject = subject
+
+	for key, values := range raw.Header {
+		if common.SearchString(mimeHeaders, key) {
+			continue
+		}
+		if common.SearchString(addressHeaders, key) {
+			p.setAddressHeader(key, values)
+			continue
+		}
+
+		decoded := make([]string, len(values))
+		for index, value := range values {
+			if decoded[index], err = p.decoder.DecodeHeader(value); err != nil {
+				decoded[index] = value
+			}
+		}
+		p.msg.SetHeader(key, decoded...)
+	}
+
+	err = p.parseEntity(textproto.MIMEHeader(raw.Header), raw.Body, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return p.msg, nil
+}
+
+//Set an address header, keeping the raw values if they cannot be parsed
+func (p *parser) setAddressHeader(key string, values []string) {
+	addrParser := &mail.AddressParser{WordDecoder: p.decoder}
+
+	var formatted []string
+	for _, value := range values {
+		addresses, err := addrParser.ParseList(value)
+		if err != nil {
+			formatted = append(formatted, value)
+			continue
+		}
+		for _, addr := range addresses {
+			formatted = append(formatted, p.msg.FormatAddress(addr.Address, addr.Name))
+		}
+	}
+
+	p.msg.header[key] = formatted
+}
+
+//Parse an entity of the message. parent is the subtype of the enclosing
+//multipart entity, if any.
+func (p *parser) parseEntity(header textproto.MIMEHeader, body io.Reader, parent string) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return p.parseMultipart(body, strings.TrimPrefix(mediaType, "multipart/"), params["boundary"])
+	}
+
+	content, err := decodeTransfer(body, header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := p.decoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if isText && disposition != "attachment" && (!p.hasBody || parent == "alternative") {
+		text, err := decodeCharset(content, params["charset"])
+		if err != nil {
+			return err
+		}
+		if !p.hasBody {
+			p.msg.body, p.msg.emailType, p.hasBody = text, mediaType, true
+		} else {
+			p.msg.AddAlternative(mediaType, text)
+		}
+		return nil
+	}
+
+	file := &common.File{
+		Name:     filename,
+		Header:   make(common.Header),
+		CopyFunc: bytesCopier(content),
+	}
+	for _, key := range []string{"Content-Type", "Content-Disposition", "Content-ID"} {
+		if value := header.Get(key); value != "" {
+			file.Header[key] = []string{value}
+		}
+	}
+
+	contentID := strings.Trim(header.Get("Content-ID"), "<>")
+	if parent == "related" && disposition != "attachment" && contentID != "" {
+		if file.Name == "" {
+			file.Name = contentID
+		}
+		p.msg.embedded = append(p.msg.embedded, file)
+		return nil
+	}
+
+	if file.Name == "" {
+		file.Name = "part-" + strconv.Itoa(len(p.msg.attachments)+1)
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			file.Name += extensions[0]
+		}
+	}
+	p.msg.attachments = append(p.msg.attachments, file)
+	return nil
+}
+
+func (p *parser) parseMultipart(body io.Reader, subtype, boundary string) error {
+	if boundary == "" {
+		return errors.New("m-mail: unable to parse message: multipart without boundary")
+	}
+
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("m-mail: unable to parse message: %v", err)
+		}
+
+		if err := p.parseEntity(part.Header, part, subtype); err != nil {
+			return err
+		}
+	}
+}
+
+//Decode the content transfer encoding of a body
+func decodeTransfer(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case string(common.Base64):
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case string(common.QuotedPrintable):
+		body = quotedprintable.NewReader(body)
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("m-mail: unable to decode %s content: %v", encoding, err)
+	}
+	return content, nil
+}
+
+//Convert text in the given charset to UTF-8
+func decodeCharset(content []byte, label string) (string, error) {
+	label = strings.ToLower(label)
+	if label == "" || label == "utf-8" || label == "us-ascii" {
+		return string(content), nil
+	}
+
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(content))
+	if err != nil {
+		// Unknown charsets are kept as is rather than failing the whole message.
+		return string(content), nil
+	}
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("m-mail: unable to decode %s content: %v", label, err)
+	}
+	return string(text), nil
+}
@@ -0,0 +1,132 @@
+package message
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"testing"
+)
+
+const rawMultipart = "From: =?UTF-8?Q?Al=C3=AFce?= <alice@example.com>\r\n" +
+	"To: bob@example.com, \"Carol\" <carol@example.com>\r\n" +
+	"Subject: =?ISO-8859-1?Q?Caf=E9?= menu\r\n" +
+	"X-Campaign: =?ISO-8859-1?Q?=E9t=E9?=\r\n" +
+	"Mime-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/related; boundary=related\r\n" +
+	"\r\n" +
+	"--related\r\n" +
+	"Content-Type: multipart/alternative; boundary=alt\r\n" +
+	"\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=C3=A9 of the day\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/html; charset=ISO-8859-1\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+Q2Fm6TwvcD4=\r\n" +
+	"--alt--\r\n" +
+	"--related\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example.com>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"UE5H\r\n" +
+	"--related--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"menu.pdf\"\r\n" +
+	"\r\n" +
+	"%PDF\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	msg, err := Parse(strings.NewReader(rawMultipart))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject := msg.GetSubject(); subject != "Café menu" {
+		t.Errorf("subject = %q", subject)
+	}
+	if from, err := msg.GetFrom(); err != nil || from != "alice@example.com" {
+		t.Errorf("GetFrom() = %q, %v", from, err)
+	}
+	if to, err := msg.GetRecipients(); err != nil || len(to) != 2 || to[1] != "carol@example.com" {
+		t.Errorf("GetRecipients() = %v, %v", to, err)
+	}
+	// Other headers are decoded, then encoded again in UTF-8 by SetHeader.
+	campaign := msg.GetHeader("X-Campaign")
+	if len(campaign) != 1 {
+		t.Fatalf("X-Campaign = %q", campaign)
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(campaign[0]); err != nil || decoded != "été" {
+		t.Errorf("X-Campaign = %q", campaign)
+	}
+
+	if body, contentType := msg.GetBody(); body != "Café of the day" || contentType != "text/plain" {
+		t.Errorf("body = %q (%s)", body, contentType)
+	}
+	parts := msg.GetAlternatives()
+	if len(parts) != 1 || parts[0].ContentType != "text/html" {
+		t.Fatalf("unexpected alternatives %v", parts)
+	}
+	var html bytes.Buffer
+	parts[0].Copier(&html)
+	if html.String() != "<p>Café</p>" {
+		t.Errorf("HTML body = %q", html.String())
+	}
+
+	embedded := msg.GetEmbedded()
+	if len(embedded) != 1 || embedded[0].Name != "logo@example.com" {
+		t.Fatalf("unexpected embedded files %v", embedded)
+	}
+	attachments := msg.GetAttachments()
+	if len(attachments) != 1 || attachments[0].Name != "menu.pdf" {
+		t.Fatalf("unexpected attachments %v", attachments)
+	}
+	var content bytes.Buffer
+	attachments[0].CopyFunc(&content)
+	if content.String() != "%PDF" {
+		t.Errorf("attachment content = %q", content.String())
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	msg := NewMessage("Hello", "<p>Hi</p>", "html")
+	msg.AddAlternative("text/plain", "Hi")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+
+	parsed, err := Parse(bytes.NewReader(msg.GetEmailBytes("bob@example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.GetSubject() != "Hello" {
+		t.Errorf("subject = %q", parsed.GetSubject())
+	}
+	if body, contentType := parsed.GetBody(); body != "<p>Hi</p>" || contentType != "text/html" {
+		t.Errorf("body = %q (%s)", body, contentType)
+	}
+	if parts := parsed.GetAlternatives(); len(parts) != 1 || parts[0].ContentType != "text/plain" {
+		t.Errorf("unexpected alternatives %v", parts)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"not a message",
+		"Content-Type: multipart/mixed\r\n\r\nno boundary",
+		"Content-Transfer-Encoding: base64\r\n\r\n!!!",
+	} {
+		if _, err := Parse(strings.NewReader(raw)); err == nil {
+			t.Errorf("Parse(%q) succeeded", raw)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net/mail"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/ishail/m-mail/common"
+	"golang.org/x/net/html/charset"
+)
+
+// A Recipient is a recipient of a personalized message. Its Data is used to
+// execute the subject, the headers and the bodies of the message as templates
+// when the message is rendered for its address, so that {{.FirstName}} is
+// replaced by the FirstName field or key of Data.
+type Recipient struct {
+	Address string
+	Name    string
+	Data    interface{}
+}
+
+// SetRecipients sets the To header of the message to the given recipients and
+// personalizes the message for each of them. Header values are decoded before
+// their templates are executed and encoded again afterwards, and the line
+// breaks of the personalized header values are replaced by spaces so that the
+// data of a recipient cannot add headers. WriteToRecipient returns an error when a
+// template cannot be parsed or executed.
+func (msg *Message) SetRecipients(recipients ...Recipient) {
+	addresses := make([]string, len(recipients))
+	msg.recipients = make(map[string]*Recipient, len(recipients))
+
+	for index := range recipients {
+		recipient := recipients[index]
+		addresses[index] = recipient.Address
+		msg.recipients[strings.ToLower(recipient.Address)] = &recipient
+	}
+
+	msg.header["To"] = addresses
+}
+
+//Return the personalization of a recipient, if any
+func (msg *Message) getRecipient(address string) *Recipient {
+	if msg.recipients == nil {
+		return nil
+	}
+	return msg.recipients[strings.ToLower(address)]
+}
+
+//Execute text as a template with the data of a recipient. The text is returned
+//unchanged if the message is not personalized for this recipient.
+func (msg *Message) personalize(text, to string, isHTML bool) (string, error) {
+	recipient := msg.getRecipient(to)
+	if recipient == nil || !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	var buff bytes.Buffer
+	var err error
+	if isHTML {
+		var tmpl *htmltemplate.Template
+		if tmpl, err = msg.htmlTemplate(text); err == nil {
+			err = tmpl.Execute(&buff, recipient.Data)
+		}
+	} else {
+		var tmpl *texttemplate.Template
+		if tmpl, err = msg.textTemplate(text); err == nil {
+			err = tmpl.Execute(&buff, recipient.Data)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("m-mail: unable to personalize the message for %s: %v", to, err)
+	}
+
+	return buff.String(), nil
+}
+
+// headerLineBreaks replaces the line breaks of personalized header values, so
+// that the data of a recipient cannot add headers to the message.
+var headerLineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+//Execute a header value as a template with the data of a recipient, replacing
+//the line breaks of the result by spaces
+func (msg *Message) personalizeHeader(value, to string) (string, error) {
+	if msg.getRecipient(to) == nil {
+		return value, nil
+	}
+
+	value, err := msg.personalize(value, to, false)
+	if err != nil {
+		return "", err
+	}
+	return headerLineBreaks.Replace(value), nil
+}
+
+// headerDecoder decodes the header values set with SetHeader before they are
+// personalized.
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+//Personalize a header value set with SetHeader for a recipient. The value is
+//decoded, executed as a template and encoded again, so that the data of the
+//recipient is encoded as well. Values without template are returned as is.
+func (msg *Message) personalizeHeaderField(field, value, to string) (string, error) {
+	if msg.getRecipient(to) == nil {
+		return value, nil
+	}
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil || !strings.Contains(decoded, "{{") {
+		return value, nil
+	}
+
+	if decoded, err = msg.personalizeHeader(decoded, to); err != nil {
+		return "", err
+	}
+	if common.SearchString(addressHeaders, field) {
+		if addresses, err := mail.ParseAddressList(decoded); err == nil {
+			values := make([]string, len(addresses))
+			for index, addr := range addresses {
+				values[index] = msg.FormatAddress(addr.Address, addr.Name)
+			}
+			return strings.Join(values, ", "), nil
+		}
+	}
+	return msg.encodeString(decoded), nil
+}
+
+//Return the parsed text template of text, parsing it on first use
+func (msg *Message) textTemplate(text string) (*texttemplate.Template, error) {
+	msg.templatesMu.Lock()
+	defer msg.templatesMu.Unlock()
+
+	if tmpl, ok := msg.templates["text:"+text].(*texttemplate.Template); ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := texttemplate.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	msg.cacheTemplate("text:"+text, tmpl)
+	return tmpl, nil
+}
+
+//Return the parsed HTML template of text, parsing it on first use
+func (msg *Message) htmlTemplate(text string) (*htmltemplate.Template, error) {
+	msg.templatesMu.Lock()
+	defer msg.templatesMu.Unlock()
+
+	if tmpl, ok := msg.templates["html:"+text].(*htmltemplate.Template); ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := htmltemplate.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	msg.cacheTemplate("html:"+text, tmpl)
+	return tmpl, nil
+}
+
+func (msg *Message) cacheTemplate(key string, tmpl interface{}) {
+	if msg.templates == nil {
+		msg.templates = make(map[string]interface{})
+	}
+	msg.templates[key] = tmpl
+}
+
+// IsPersonalized reports whether the message is rendered differently for each
+// recipient, besides its To header. HTML filters, such as the ones added by
+// TrackClicks and TrackOpens, are given the recipient and make the message
+// personalized. When it is not, the message written by WriteTo can be sent to
+// all of its recipients at once.
+func (msg *Message) IsPersonalized() bool {
+	return len(msg.recipients) > 0 || len(msg.headerFuncs) > 0 || msg.trackingUrlFunc != nil ||
+		len(msg.htmlFilters) > 0
+}
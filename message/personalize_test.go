@@ -0,0 +1,133 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/common"
+)
+
+func TestPersonalize(t *testing.T) {
+	msg := NewMessage("Hello {{.Name}}", "<p>Dear {{.Name}}</p>", "html")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetRecipients(
+		Recipient{Address: "bob@example.com", Data: map[string]string{"Name": "Bob"}},
+		Recipient{Address: "carol@example.com", Data: map[string]string{"Name": "Carol"}},
+	)
+
+	var buff bytes.Buffer
+	if _, err := msg.WriteToRecipient(&buff, "carol@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	out := buff.String()
+	if !strings.Contains(out, "Subject: Hello Carol\r\n") || !strings.Contains(out, "Dear Carol") {
+		t.Fatalf("message not personalized:\n%s", out)
+	}
+	if strings.Contains(out, "Bob") {
+		t.Fatalf("message personalized for the wrong recipient:\n%s", out)
+	}
+}
+
+func TestPersonalizeHeaderInjection(t *testing.T) {
+	msg := NewMessage("Hello {{.Name}}", "Hi", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("X-Greeting", "Hi {{.Name}}")
+	msg.SetRecipients(Recipient{
+		Address: "bob@example.com",
+		Data:    map[string]string{"Name": "Bob\r\nBcc: mallory@example.com\nX-Evil: 1"},
+	})
+
+	var buff bytes.Buffer
+	if _, err := msg.WriteToRecipient(&buff, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	out := buff.String()
+	for _, line := range strings.Split(out, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Evil:") {
+			t.Fatalf("recipient data added a header:\n%s", out)
+		}
+	}
+	if !strings.Contains(out, "Subject: Hello Bob Bcc: mallory@example.com X-Evil: 1\r\n") {
+		t.Fatalf("unexpected subject:\n%s", out)
+	}
+}
+
+func TestPersonalizeEncoding(t *testing.T) {
+	msg := NewMessage("Hello {{.Name}}", "Hi", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("X-Greeting", "Grüße {{.Name}}")
+	msg.SetHeader("Reply-To", "{{.Name}} <support@example.com>")
+	msg.SetRecipients(Recipient{Address: "bob@example.com", Data: map[string]string{"Name": "Zoë"}})
+
+	raw, err := mail.ReadMessage(bytes.NewReader(msg.GetEmailBytes("bob@example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := new(mime.WordDecoder)
+	for key, want := range map[string]string{"Subject": "Hello Zoë", "X-Greeting": "Grüße Zoë"} {
+		value := raw.Header.Get(key)
+		if decoded, err := decoder.DecodeHeader(value); err != nil || decoded != want || value == want {
+			t.Errorf("%s = %q, want %q encoded", key, value, want)
+		}
+	}
+	if addr, err := mail.ParseAddress(raw.Header.Get("Reply-To")); err != nil ||
+		addr.Name != "Zoë" || addr.Address != "support@example.com" {
+		t.Errorf("Reply-To = %q", raw.Header.Get("Reply-To"))
+	}
+}
+
+func TestPersonalizeTrackingPixel(t *testing.T) {
+	msg := NewMessage("Hello", "Hi {{.Name}}", "text/plain")
+	msg.AddTrackingUrl("https://example.com/open")
+	msg.SetRecipients(Recipient{Address: "bob@example.com", Data: map[string]string{"Name": "<Bob>"}})
+
+	out := string(msg.GetEmailBytes("bob@example.com"))
+	if !strings.Contains(out, `<div dir=3D"ltr">Hi &lt;Bob&gt;<img`) {
+		t.Errorf("unexpected HTML copy of the body in:\n%s", out)
+	}
+}
+
+func TestPersonalizePartError(t *testing.T) {
+	msg := NewMessage("Hello", "Hi", "text/plain")
+	msg.parts = append(msg.parts, &common.Part{
+		ContentType: "text/html",
+		Copier:      func(io.Writer) error { return errors.New("unreadable part") },
+	})
+	msg.SetRecipients(Recipient{Address: "bob@example.com"})
+
+	if _, err := msg.WriteToRecipient(io.Discard, "bob@example.com"); err == nil {
+		t.Fatal("the unreadable part was ignored")
+	}
+}
+
+func TestPersonalizeTemplateErrors(t *testing.T) {
+	for name, msg := range map[string]*Message{
+		"subject parse": NewMessage("Hello {{.Name", "Hi", "text/plain"),
+		"body execute":  NewMessage("Hello", `Hi {{template "missing"}}`, "text/plain"),
+		"header parse":  NewMessage("Hello", "Hi", "text/plain", func(msg *Message) { msg.SetHeader("X-Greeting", "{{end}}") }),
+	} {
+		msg.SetRecipients(Recipient{Address: "bob@example.com", Data: map[string]string{"Name": "Bob"}})
+
+		var buff bytes.Buffer
+		if _, err := msg.WriteToRecipient(&buff, "bob@example.com"); err == nil {
+			t.Errorf("%s: the invalid template was sent:\n%s", name, buff.String())
+		}
+	}
+}
+
+func TestIsPersonalized(t *testing.T) {
+	msg := NewMessage("Hello", "<p>Hi</p>", "text/html")
+	if msg.IsPersonalized() {
+		t.Fatal("a plain message is personalized")
+	}
+
+	msg.AddHTMLFilter(func(html, to string) string { return html + to })
+	if !msg.IsPersonalized() {
+		t.Fatal("a message with an HTML filter is not personalized")
+	}
+}
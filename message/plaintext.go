@@ -0,0 +1,453 @@
+package message
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText converts an HTML document into a readable plain text version.
+// Scripts and styles are dropped, links are turned into numbered footnotes,
+// lists into bullets and tables into aligned columns.
+func HTMLToText(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return body
+	}
+
+	w := &textWriter{links: &linkList{index: make(map[string]int)}}
+	w.walk(doc)
+
+	text := strings.TrimSpace(w.buff.String())
+	if len(w.links.urls) > 0 {
+		text += "\n\n"
+		for index, url := range w.links.urls {
+			text += "[" + strconv.Itoa(index+1) + "] " + url + "\n"
+		}
+	}
+
+	return text
+}
+
+// linkList numbers the links of a document in order of appearance.
+type linkList struct {
+	urls  []string
+	index map[string]int
+}
+
+func (links *linkList) add(url string) int {
+	if n, ok := links.index[url]; ok {
+		return n
+	}
+	links.urls = append(links.urls, url)
+	links.index[url] = len(links.urls)
+	return len(links.urls)
+}
+
+// textWriter accumulates the text of an HTML tree. Line breaks are only written
+// when some text follows them so blocks never produce leading or trailing blank
+// lines.
+type textWriter struct {
+	buff   strings.Builder
+	links  *linkList
+	prefix []string
+	marker string
+	lists  []int
+
+	newlines    int
+	gapPrefix   string
+	space       bool
+	lineStarted bool
+	pre         int
+}
+
+// skippedElements are the elements whose content is never rendered.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+}
+
+// blockElements are the elements rendered on their own lines. The value is the
+// number of line breaks around them.
+var blockElements = map[atom.Atom]int{
+	atom.Address: 1, atom.Article: 1, atom.Aside: 1, atom.Div: 1, atom.Dl: 1,
+	atom.Dt: 1, atom.Dd: 1, atom.Fieldset: 1, atom.Figure: 1, atom.Footer: 1,
+	atom.Form: 1, atom.Header: 1, atom.Main: 1, atom.Nav: 1, atom.Section: 1,
+	atom.Tr: 1, atom.Td: 1, atom.Th: 1, atom.Center: 1,
+	atom.P: 2, atom.H1: 2, atom.H2: 2, atom.H3: 2, atom.H4: 2, atom.H5: 2,
+	atom.H6: 2, atom.Pre: 2, atom.Blockquote: 2, atom.Ul: 2, atom.Ol: 2,
+	atom.Table: 2,
+}
+
+func (w *textWriter) walk(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		w.writeText(node.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.walkChildren(node)
+		return
+	}
+
+	if skippedElements[node.DataAtom] {
+		return
+	}
+
+	switch node.DataAtom {
+	case atom.Br:
+		w.lineBreak()
+		return
+	case atom.Hr:
+		w.breakLines(2)
+		w.writeWord(strings.Repeat("-", 20))
+		w.breakLines(2)
+		return
+	case atom.Img:
+		if alt := strings.TrimSpace(getAttr(node, "alt")); alt != "" {
+			w.writeText(alt)
+		}
+		return
+	case atom.Table:
+		if isDataTable(node) {
+			w.writeTable(node)
+			return
+		}
+	case atom.A:
+		w.walkChildren(node)
+		w.writeLink(node)
+		return
+	case atom.Li:
+		w.writeListItem(node)
+		return
+	}
+
+	n := blockElements[node.DataAtom]
+	if (node.DataAtom == atom.Ul || node.DataAtom == atom.Ol) && len(w.lists) > 0 {
+		n = 1
+	}
+	w.breakLines(n)
+
+	switch node.DataAtom {
+	case atom.Pre:
+		w.pre++
+		w.walkChildren(node)
+		w.pre--
+	case atom.Blockquote:
+		w.prefix = append(w.prefix, "> ")
+		w.walkChildren(node)
+		w.popPrefix()
+	case atom.Ul, atom.Ol:
+		w.lists = append(w.lists, 0)
+		if node.DataAtom == atom.Ul {
+			w.lists[len(w.lists)-1] = -1
+		}
+		w.walkChildren(node)
+		w.lists = w.lists[:len(w.lists)-1]
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		start := w.buff.Len()
+		w.walkChildren(node)
+		if node.DataAtom == atom.H1 || node.DataAtom == atom.H2 {
+			w.underline(start, node.DataAtom == atom.H1)
+		}
+	default:
+		w.walkChildren(node)
+	}
+
+	w.breakLines(n)
+}
+
+func (w *textWriter) walkChildren(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		w.walk(child)
+	}
+}
+
+func (w *textWriter) writeListItem(node *html.Node) {
+	w.breakLines(1)
+
+	marker := "* "
+	if len(w.lists) > 0 && w.lists[len(w.lists)-1] >= 0 {
+		w.lists[len(w.lists)-1]++
+		marker = strconv.Itoa(w.lists[len(w.lists)-1]) + ". "
+	}
+
+	w.marker = marker
+	w.prefix = append(w.prefix, strings.Repeat(" ", len(marker)))
+	w.walkChildren(node)
+	w.popPrefix()
+	w.marker = ""
+
+	w.breakLines(1)
+}
+
+func (w *textWriter) writeLink(node *html.Node) {
+	href := strings.TrimSpace(getAttr(node, "href"))
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+		return
+	}
+
+	text := strings.TrimSpace(textContent(node))
+	if text == href || text == strings.TrimPrefix(href, "mailto:") {
+		return
+	}
+
+	w.space = true
+	w.writeWord("[" + strconv.Itoa(w.links.add(href)) + "]")
+}
+
+// writeTable writes each row of a table on its own line with its cells padded
+// so the columns are aligned. Header rows are underlined.
+func (w *textWriter) writeTable(table *html.Node) {
+	var rows [][]string
+	var headers []bool
+	var widths []int
+
+	for _, row := range tableRows(table) {
+		var cells []string
+		isHeader := true
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode ||
+				(cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+				continue
+			}
+			if cell.DataAtom == atom.Td {
+				isHeader = false
+			}
+
+			cw := &textWriter{links: w.links}
+			cw.walkChildren(cell)
+			text := strings.Join(strings.Fields(cw.buff.String()), " ")
+
+			if len(cells) == len(widths) {
+				widths = append(widths, 0)
+			}
+			if width := utf8.RuneCountInString(text); width > widths[len(cells)] {
+				widths[len(cells)] = width
+			}
+			cells = append(cells, text)
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+			headers = append(headers, isHeader)
+		}
+	}
+
+	w.breakLines(2)
+	for index, cells := range rows {
+		var line []string
+		for column, text := range cells {
+			if column < len(cells)-1 {
+				text += strings.Repeat(" ", widths[column]-utf8.RuneCountInString(text))
+			}
+			line = append(line, text)
+		}
+		w.writeLine(strings.Join(line, "  "))
+
+		if headers[index] {
+			var underline []string
+			for column := range cells {
+				underline = append(underline, strings.Repeat("-", widths[column]))
+			}
+			w.writeLine(strings.Join(underline, "  "))
+		}
+	}
+	w.breakLines(2)
+}
+
+// isDataTable reports whether a table holds tabular data rather than being used
+// for layout, which is the case of most tables in HTML emails. Layout tables
+// have a single column or contain blocks, and are rendered like any other
+// block.
+func isDataTable(table *html.Node) bool {
+	columns := 0
+	for _, row := range tableRows(table) {
+		cells := 0
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode {
+				continue
+			}
+			if hasBlocks(cell) {
+				return false
+			}
+			cells++
+		}
+		if cells > columns {
+			columns = cells
+		}
+	}
+
+	return columns > 1
+}
+
+//Check whether a node contains block elements
+func hasBlocks(node *html.Node) bool {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		if child.DataAtom == atom.Br || blockElements[child.DataAtom] > 0 || hasBlocks(child) {
+			return true
+		}
+	}
+	return false
+}
+
+//Return the rows of a table, skipping the rows of nested tables
+func tableRows(node *html.Node) []*html.Node {
+	var rows []*html.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.DataAtom {
+		case atom.Tr:
+			rows = append(rows, child)
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			rows = append(rows, tableRows(child)...)
+		}
+	}
+	return rows
+}
+
+// underline adds a line of '=' or '-' below the text written since start.
+func (w *textWriter) underline(start int, double bool) {
+	title := w.buff.String()[start:]
+	if index := strings.LastIndexByte(title, '\n'); index != -1 {
+		title = title[index+1:]
+	}
+	title = strings.TrimPrefix(title, strings.Join(w.prefix, ""))
+	if title == "" {
+		return
+	}
+
+	char := "-"
+	if double {
+		char = "="
+	}
+	w.writeLine(strings.Repeat(char, utf8.RuneCountInString(title)))
+}
+
+func (w *textWriter) writeText(text string) {
+	if w.pre > 0 {
+		for index, line := range strings.Split(text, "\n") {
+			if index > 0 {
+				w.lineBreak()
+			}
+			if line != "" {
+				w.writeRaw(line)
+			}
+		}
+		return
+	}
+
+	if text != "" && isSpace(text[0]) {
+		w.space = true
+	}
+	for _, word := range strings.Fields(text) {
+		w.writeWord(word)
+		w.space = true
+	}
+	if text != "" && !isSpace(text[len(text)-1]) {
+		w.space = false
+	}
+}
+
+func (w *textWriter) writeWord(word string) {
+	if w.lineStarted && w.newlines == 0 && w.space {
+		w.buff.WriteByte(' ')
+	}
+	w.writeRaw(word)
+}
+
+func (w *textWriter) writeLine(line string) {
+	w.breakLines(1)
+	w.writeRaw(line)
+	w.breakLines(1)
+}
+
+// writeRaw writes text after the pending line breaks and the prefix of the
+// current line.
+func (w *textWriter) writeRaw(text string) {
+	if w.newlines > 0 {
+		for i := 0; i < w.newlines; i++ {
+			if i > 0 {
+				w.buff.WriteString(w.gapPrefix)
+			}
+			w.buff.WriteByte('\n')
+		}
+		w.newlines = 0
+		w.lineStarted = false
+	}
+
+	if !w.lineStarted {
+		prefix := strings.Join(w.prefix, "")
+		if w.marker != "" {
+			prefix = prefix[:len(prefix)-len(w.marker)] + w.marker
+			w.marker = ""
+		}
+		w.buff.WriteString(prefix)
+		w.lineStarted = true
+	}
+
+	w.buff.WriteString(text)
+	w.space = false
+}
+
+// popPrefix removes the innermost prefix. The pending blank lines now belong to
+// the enclosing block.
+func (w *textWriter) popPrefix() {
+	w.prefix = w.prefix[:len(w.prefix)-1]
+	w.gapPrefix = strings.TrimRight(strings.Join(w.prefix, ""), " ")
+}
+
+// lineBreak adds a line break before the next text.
+func (w *textWriter) lineBreak() {
+	if w.buff.Len() > 0 {
+		w.newlines++
+		w.gapPrefix = strings.TrimRight(strings.Join(w.prefix, ""), " ")
+	}
+}
+
+// breakLines ensures there are at least n line breaks before the next text.
+func (w *textWriter) breakLines(n int) {
+	if w.buff.Len() > 0 && n > w.newlines {
+		w.newlines = n
+		w.gapPrefix = strings.TrimRight(strings.Join(w.prefix, ""), " ")
+	}
+}
+
+//Return the text contained in a node and its children
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
+
+//Return the value of an attribute of a node
+func getAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,82 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name, html, text string
+	}{
+		{
+			"blocks",
+			"<html><head><style>p{}</style><script>x()</script></head><body><h1>Title</h1><p>Hello   <b>world</b>,<br>second line</p></body></html>",
+			"Title\n=====\n\nHello world,\nsecond line",
+		},
+		{
+			"links",
+			`<p>Read <a href="https://example.com/a">the docs</a> or <a href="https://example.com/a">again</a>, <a href="mailto:bob@example.com">bob@example.com</a>.</p>`,
+			"Read the docs [1] or again [1], bob@example.com.\n\n[1] https://example.com/a\n",
+		},
+		{
+			"lists",
+			"<ul><li>one</li><li>two<ol><li>a</li><li>b</li></ol></li></ul>",
+			"* one\n* two\n  1. a\n  2. b",
+		},
+		{
+			"blockquote",
+			"<blockquote><p>quoted</p><p>text</p></blockquote>",
+			"> quoted\n>\n> text",
+		},
+		{
+			"data table",
+			"<table><tr><th>Item</th><th>Qty</th></tr><tr><td>Apple</td><td>10</td></tr></table>",
+			"Item   Qty\n-----  ---\nApple  10",
+		},
+		{
+			"layout table",
+			"<table><tr><td><p>Hello</p></td></tr><tr><td><p>World</p></td></tr></table>",
+			"Hello\n\nWorld",
+		},
+		{
+			"pre",
+			"<p>Code:</p><pre>a\n  b</pre>",
+			"Code:\n\na\n  b",
+		},
+	}
+
+	for _, test := range tests {
+		if text := HTMLToText(test.html); text != test.text {
+			t.Errorf("%s: HTMLToText() = %q, want %q", test.name, text, test.text)
+		}
+	}
+}
+
+func TestPlainTextAlternative(t *testing.T) {
+	msg := NewMessage("Hello", `<p>Hi <a href="https://example.com">there</a></p>`, "html", SetPlainTextAlternative())
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+
+	out := string(msg.GetEmailBytes("bob@example.com"))
+	if !strings.Contains(out, "Content-Type: multipart/alternative") {
+		t.Fatalf("no alternative:\n%s", out)
+	}
+	text := strings.Index(out, "Hi there [1]\r\n\r\n[1] https://example.com")
+	html := strings.Index(out, "Content-Type: text/html")
+	if text == -1 || html == -1 || text > html {
+		t.Fatalf("the plain text version is not the first part:\n%s", out)
+	}
+}
+
+func TestPlainTextAlternativeExisting(t *testing.T) {
+	msg := NewMessage("Hello", "<p>Generated</p>", "html", SetPlainTextAlternative())
+	msg.AddAlternative("text/plain", "Written by hand")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+
+	out := string(msg.GetEmailBytes("bob@example.com"))
+	if strings.Count(out, "Content-Type: text/plain") != 1 || !strings.Contains(out, "Written by hand") {
+		t.Fatalf("the plain text part was replaced or duplicated:\n%s", out)
+	}
+}
@@ -2,10 +2,28 @@ package message
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/ishail/m-mail/common"
 )
 
+// Boundaries separating the parts of the multipart sections.
+const (
+	alternativeBoundary = "boundary-type-1234567892-alt"
+	relatedBoundary     = "boundary-type-1234567892-rel"
+	mixedBoundary       = "boundary-type-1234567892-mix"
+)
+
+// maxLineLen is the maximum length of a line of encoded content, as defined in
+// RFC 2045.
+const maxLineLen = 76
+
 // SetCharset is a message setting to set the charset of the email.
 func SetCharset(charset string) MessageSetting {
 	return func(msg *Message) {
@@ -20,6 +38,32 @@ func SetEncoding(enc common.Encoding) MessageSetting {
 	}
 }
 
+// SetCategory is a message setting to set the category of the email, such as
+// the name of a mailing list. The category scopes the suppressed addresses.
+func SetCategory(category string) MessageSetting {
+	return func(msg *Message) {
+		msg.category = category
+	}
+}
+
+// SetPlainTextAlternative is a message setting to send a plain text version of
+// HTML emails. The text is generated from the HTML body with HTMLToText and added
+// as the first part of the multipart/alternative section, unless the message
+// already has a plain text part.
+func SetPlainTextAlternative() MessageSetting {
+	return func(msg *Message) {
+		msg.textAlternative = true
+	}
+}
+
+// SetHTMLFilters is a message setting to add filters applied to the HTML parts
+// of the email. See Message.AddHTMLFilter.
+func SetHTMLFilters(filters ...HTMLFilter) MessageSetting {
+	return func(msg *Message) {
+		msg.htmlFilters = append(msg.htmlFilters, filters...)
+	}
+}
+
 //Returns a single header of message as RFC format
 func getHeaderBytes(key string, value ...string) []byte {
 	var header bytes.Buffer
@@ -57,3 +101,95 @@ func getHeaderBytes(key string, value ...string) []byte {
 
 	return header.Bytes()
 }
+
+//Returns a new RFC 5322 message id
+func generateMessageID() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	return "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + hex.EncodeToString(random) +
+		"@" + host + ">"
+}
+
+//Returns a Copier that writes the given string
+func stringCopier(value string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, value)
+		return err
+	}
+}
+
+//Returns a Copier that writes suffix after what copier writes
+func appendCopier(copier func(io.Writer) error, suffix string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		if err := copier(w); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, suffix)
+		return err
+	}
+}
+
+//Returns a writer encoding its input with the given encoding into w
+func newEncoder(w io.Writer, enc common.Encoding) io.WriteCloser {
+	if enc == common.Base64 {
+		return base64.NewEncoder(base64.StdEncoding, &base64LineWriter{w: w})
+	}
+
+	return common.NewQPWriter(w)
+}
+
+// base64LineWriter limits text encoded in base64 to 76 characters per line.
+type base64LineWriter struct {
+	w       io.Writer
+	lineLen int
+}
+
+func (w *base64LineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p)+w.lineLen > maxLineLen {
+		if _, err := w.w.Write(p[:maxLineLen-w.lineLen]); err != nil {
+			return n, err
+		}
+		if _, err := w.w.Write([]byte("\r\n")); err != nil {
+			return n, err
+		}
+		p = p[maxLineLen-w.lineLen:]
+		n += maxLineLen - w.lineLen
+		w.lineLen = 0
+	}
+
+	if _, err := w.w.Write(p); err != nil {
+		return n, err
+	}
+	w.lineLen += len(p)
+
+	return n + len(p), nil
+}
+
+// countWriter counts the bytes written to w and keeps the first error, after
+// which nothing more is written.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+func (w *countWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -41,3 +41,16 @@ func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
 		return nil, fmt.Errorf("m-mail: unexpected server challenge: %s", fromServer)
 	}
 }
+
+// sslAuth is an smtp.Auth used over an SSL connection wrapped by a transcript.
+// The client cannot tell that the wrapped connection is encrypted, so the
+// mechanism is told instead.
+type sslAuth struct {
+	smtp.Auth
+}
+
+func (a *sslAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	info := *server
+	info.TLS = true
+	return a.Auth.Start(&info)
+}
@@ -0,0 +1,399 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A Config is the configuration of an SMTP server, as read by LoadConfig. The
+// keys of the file are the json tags of the fields, in every format.
+type Config struct {
+	Network  string `json:"network"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// PasswordFile is the path of a file holding the password, so that it
+	// does not have to be written in the configuration. It is used when
+	// Password is empty, and read again when it changes.
+	PasswordFile string `json:"password_file"`
+	// PasswordCommand is a credential helper command, with its arguments,
+	// run to get the password when Password is empty. See
+	// HelperCredentials.
+	PasswordCommand []string   `json:"password_command"`
+	SSL             bool       `json:"ssl"`
+	TLS             TLSOptions `json:"tls"`
+	LocalName       string     `json:"local_name"`
+	// Proxy is the url of the proxy the server is reached through, see
+	// Dialer.ProxyURL.
+	Proxy                string `json:"proxy"`
+	ProxyFromEnvironment bool   `json:"proxy_from_environment"`
+	// Timeout bounds connecting and authenticating to the server, see
+	// Dialer.Timeout.
+	Timeout Duration `json:"timeout"`
+	// PoolSize is the maximum number of connections of the Pool returned by
+	// Config.Pool.
+	PoolSize int         `json:"pool_size"`
+	Retry    RetryPolicy `json:"retry"`
+}
+
+// TLSOptions are the TLS settings of a Config.
+type TLSOptions struct {
+	// Policy is "opportunistic", the default, "mandatory" or "none". See
+	// StartTLSPolicy.
+	Policy string `json:"policy"`
+	// CAFile is the path of a PEM bundle of the certificate authorities
+	// trusted to verify the server. The system ones are used by default.
+	CAFile string `json:"ca_file"`
+	// CertFile and KeyFile are the paths of the PEM certificate and key the
+	// client authenticates with.
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// A Duration is a time.Duration read from a configuration file, either as a
+// string such as "30s" or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("m-mail: invalid duration %s", data)
+	}
+	return nil
+}
+
+//Parse a duration given as a string, either in the time.ParseDuration format
+//or as a number of seconds
+func parseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return Duration(seconds * float64(time.Second)), nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("m-mail: invalid duration %q", value)
+	}
+	return Duration(parsed), nil
+}
+
+// LoadConfig reads the configuration of an SMTP server from the file at path,
+// in the YAML, TOML or JSON format depending on its extension, then overrides
+// it with the MMAIL_* environment variables. When path is empty, only the
+// environment is read.
+//
+// The file can define named profiles under the "profiles" key. The selected
+// profile, or the one named by the MMAIL_PROFILE environment variable when
+// profile is empty, is merged over the top level settings:
+//
+//	host: smtp.example.com
+//	username: alerts
+//	password_file: /run/secrets/smtp
+//	profiles:
+//	  staging:
+//	    host: smtp.staging.example.com
+//	    tls:
+//	      policy: none
+//
+// The environment variables are MMAIL_NETWORK, MMAIL_HOST, MMAIL_PORT,
+// MMAIL_USERNAME, MMAIL_PASSWORD, MMAIL_PASSWORD_FILE, MMAIL_SSL,
+// MMAIL_TLS_POLICY, MMAIL_CA_FILE, MMAIL_CERT_FILE, MMAIL_KEY_FILE,
+// MMAIL_SERVER_NAME, MMAIL_LOCAL_NAME, MMAIL_PROXY, MMAIL_TIMEOUT,
+// MMAIL_POOL_SIZE, MMAIL_RETRY_ATTEMPTS, MMAIL_RETRY_BACKOFF and
+// MMAIL_RETRY_MAX_BACKOFF.
+func LoadConfig(path, profile string) (*Config, error) {
+	if profile == "" {
+		profile = os.Getenv("MMAIL_PROFILE")
+	}
+
+	settings := map[string]interface{}{}
+	if path != "" {
+		var err error
+		if settings, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	profiles, _ := settings["profiles"].(map[string]interface{})
+	delete(settings, "profiles")
+	if profile != "" {
+		overrides, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("m-mail: unknown configuration profile %q", profile)
+		}
+		mergeSettings(settings, overrides)
+	}
+
+	// The settings are decoded through JSON so that the three formats share
+	// the json tags of Config.
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("m-mail: invalid configuration file %s: %v", path, err)
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("m-mail: invalid configuration file %s: %v", path, err)
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//Read the configuration file at path into generic settings
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	case ".json":
+		err = json.Unmarshal(data, &settings)
+	default:
+		return nil, fmt.Errorf("m-mail: unsupported configuration file %s, expected .yaml, .toml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("m-mail: invalid configuration file %s: %v", path, err)
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return settings, nil
+}
+
+//Merge overrides into settings, nested sections being merged key by key
+func mergeSettings(settings, overrides map[string]interface{}) {
+	for key, value := range overrides {
+		section, ok := value.(map[string]interface{})
+		if current, isMap := settings[key].(map[string]interface{}); ok && isMap {
+			mergeSettings(current, section)
+			continue
+		}
+		settings[key] = value
+	}
+}
+
+//Override the configuration with the MMAIL_* environment variables
+func (cfg *Config) loadEnv() error {
+	stringFields := map[string]*string{
+		"MMAIL_NETWORK":       &cfg.Network,
+		"MMAIL_HOST":          &cfg.Host,
+		"MMAIL_USERNAME":      &cfg.Username,
+		"MMAIL_PASSWORD":      &cfg.Password,
+		"MMAIL_PASSWORD_FILE": &cfg.PasswordFile,
+		"MMAIL_TLS_POLICY":    &cfg.TLS.Policy,
+		"MMAIL_CA_FILE":       &cfg.TLS.CAFile,
+		"MMAIL_CERT_FILE":     &cfg.TLS.CertFile,
+		"MMAIL_KEY_FILE":      &cfg.TLS.KeyFile,
+		"MMAIL_SERVER_NAME":   &cfg.TLS.ServerName,
+		"MMAIL_LOCAL_NAME":    &cfg.LocalName,
+		"MMAIL_PROXY":         &cfg.Proxy,
+	}
+	for name, field := range stringFields {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+	// A password file given by the environment replaces the password of the
+	// configuration file.
+	if os.Getenv("MMAIL_PASSWORD_FILE") != "" && os.Getenv("MMAIL_PASSWORD") == "" {
+		cfg.Password = ""
+	}
+
+	intFields := map[string]*int{
+		"MMAIL_PORT":           &cfg.Port,
+		"MMAIL_POOL_SIZE":      &cfg.PoolSize,
+		"MMAIL_RETRY_ATTEMPTS": &cfg.Retry.Attempts,
+	}
+	for name, field := range intFields {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("m-mail: invalid %s %q", name, value)
+			}
+			*field = parsed
+		}
+	}
+
+	durationFields := map[string]*Duration{
+		"MMAIL_TIMEOUT":           &cfg.Timeout,
+		"MMAIL_RETRY_BACKOFF":     &cfg.Retry.Backoff,
+		"MMAIL_RETRY_MAX_BACKOFF": &cfg.Retry.MaxBackoff,
+	}
+	for name, field := range durationFields {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := parseDuration(value)
+			if err != nil {
+				return fmt.Errorf("m-mail: invalid %s %q", name, value)
+			}
+			*field = parsed
+		}
+	}
+
+	if value := os.Getenv("MMAIL_SSL"); value != "" {
+		ssl, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("m-mail: invalid MMAIL_SSL %q", value)
+		}
+		cfg.SSL = ssl
+	}
+	return nil
+}
+
+// Dialer returns a Dialer configured by cfg. The CA bundle and the client
+// certificate are read when it is called, while the password file and the
+// password command are used by the Credentials of the dialer each time it
+// dials. The port defaults to 465 with SSL and to 587 otherwise.
+func (cfg *Config) Dialer() (*Dialer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("m-mail: no SMTP host configured")
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+		if cfg.SSL {
+			port = 465
+		}
+	}
+
+	policy, err := parseStartTLSPolicy(cfg.TLS.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := NewDialer(cfg.Host, port, cfg.Username, cfg.Password)
+	if cfg.Password == "" && len(cfg.PasswordCommand) > 0 {
+		helper := NewHelperCredentials(cfg.PasswordCommand[0], cfg.PasswordCommand[1:]...)
+		helper.Username = cfg.Username
+		dialer.Credentials = helper
+	} else if cfg.Password == "" && cfg.PasswordFile != "" {
+		// The file is read once here so that a missing file is reported
+		// before dialing.
+		creds := NewFileCredentials(cfg.Username, cfg.PasswordFile)
+		if _, _, err := creds.Credentials(context.Background(), cfg.Host); err != nil {
+			return nil, err
+		}
+		dialer.Credentials = creds
+	}
+	dialer.Network = cfg.Network
+	dialer.SSL = dialer.SSL || cfg.SSL
+	dialer.StartTLSPolicy = policy
+	dialer.LocalName = cfg.LocalName
+	dialer.ProxyURL = cfg.Proxy
+	dialer.ProxyFromEnvironment = cfg.ProxyFromEnvironment
+	dialer.Timeout = time.Duration(cfg.Timeout)
+
+	// The path of a unix socket is not a server name, so one must be given to
+	// verify the certificate of the server.
+	serverName := cfg.Host
+	if cfg.Network == "unix" {
+		serverName = ""
+	}
+	if dialer.TLSConfig, err = cfg.TLS.config(serverName); err != nil {
+		return nil, err
+	}
+	return dialer, nil
+}
+
+//Parse the name of a StartTLSPolicy
+func parseStartTLSPolicy(name string) (StartTLSPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "opportunistic":
+		return OpportunisticStartTLS, nil
+	case "mandatory":
+		return MandatoryStartTLS, nil
+	case "none":
+		return NoStartTLS, nil
+	}
+	return 0, fmt.Errorf("m-mail: invalid TLS policy %q", name)
+}
+
+// Pool returns a Pool of connections dialed with the Dialer of cfg, using its
+// pool size and retry policy.
+func (cfg *Config) Pool() (*Pool, error) {
+	dialer, err := cfg.Dialer()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := NewPool(dialer, cfg.PoolSize)
+	pool.Retry = cfg.Retry
+	return pool, nil
+}
+
+//Return the tls.Config of the options, or nil when the defaults are used. The
+//server name defaults to host, and is required when host is empty.
+func (options TLSOptions) config(host string) (*tls.Config, error) {
+	if options == (TLSOptions{Policy: options.Policy}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		if host == "" {
+			return nil, errors.New("m-mail: tls.server_name is required to use TLS over a unix socket")
+		}
+		config.ServerName = host
+	}
+
+	if options.CAFile != "" {
+		data, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("m-mail: unable to read CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("m-mail: no certificate found in CA file %s", options.CAFile)
+		}
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("m-mail: unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,102 @@
+package sender
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"mail.yaml": "host: smtp.example.com\nusername: alerts\ntimeout: 5s\n" +
+			"retry:\n  attempts: 3\n  backoff: 1\n" +
+			"profiles:\n  staging:\n    host: staging.example.com\n    port: 2525\n    retry:\n      max_backoff: 2s\n",
+		"mail.toml": "host = \"smtp.example.com\"\nusername = \"alerts\"\ntimeout = \"5s\"\n" +
+			"[retry]\nattempts = 3\nbackoff = 1\n" +
+			"[profiles.staging]\nhost = \"staging.example.com\"\nport = 2525\n[profiles.staging.retry]\nmax_backoff = \"2s\"\n",
+		"mail.json": `{"host": "smtp.example.com", "username": "alerts", "timeout": "5s",
+			"retry": {"attempts": 3, "backoff": 1},
+			"profiles": {"staging": {"host": "staging.example.com", "port": 2525, "retry": {"max_backoff": "2s"}}}}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadConfig(path, "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Host != "smtp.example.com" || cfg.Port != 0 || cfg.Username != "alerts" ||
+			time.Duration(cfg.Timeout) != 5*time.Second || cfg.Retry.Attempts != 3 ||
+			time.Duration(cfg.Retry.Backoff) != time.Second {
+			t.Errorf("%s: unexpected configuration %+v", name, cfg)
+		}
+
+		// The profile overrides the settings of the file, and only them.
+		cfg, err = LoadConfig(path, "staging")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Host != "staging.example.com" || cfg.Port != 2525 || cfg.Username != "alerts" ||
+			cfg.Retry.Attempts != 3 || time.Duration(cfg.Retry.MaxBackoff) != 2*time.Second {
+			t.Errorf("%s: unexpected configuration %+v", name, cfg)
+		}
+
+		if _, err := LoadConfig(path, "production"); err == nil {
+			t.Errorf("%s: an unknown profile was loaded", name)
+		}
+	}
+}
+
+func TestLoadConfigEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.yaml")
+	content := "host: smtp.example.com\nport: 587\nusername: alerts\npassword: from-file\n" +
+		"profiles:\n  staging:\n    host: staging.example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence over the file, and selects its
+	// profile.
+	setenv(t, map[string]string{
+		"MMAIL_PROFILE":  "staging",
+		"MMAIL_PORT":     "465",
+		"MMAIL_SSL":      "true",
+		"MMAIL_PASSWORD": "from-env",
+		"MMAIL_TIMEOUT":  "10",
+	})
+	cfg, err := LoadConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "staging.example.com" || cfg.Port != 465 || !cfg.SSL || cfg.Username != "alerts" ||
+		cfg.Password != "from-env" || time.Duration(cfg.Timeout) != 10*time.Second {
+		t.Errorf("unexpected configuration %+v", cfg)
+	}
+
+	setenv(t, map[string]string{"MMAIL_PORT": "smtp"})
+	if _, err := LoadConfig(path, ""); err == nil {
+		t.Error("an invalid MMAIL_PORT was accepted")
+	}
+}
+
+func TestConfigDialerUnixTLS(t *testing.T) {
+	cfg := &Config{Network: "unix", Host: "/run/smtp.sock", TLS: TLSOptions{InsecureSkipVerify: true}}
+	if _, err := cfg.Dialer(); err == nil {
+		t.Fatal("the socket path was used as TLS server name")
+	}
+
+	cfg.TLS.ServerName = "smtp.example.com"
+	dialer, err := cfg.Dialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialer.TLSConfig.ServerName != "smtp.example.com" {
+		t.Errorf("ServerName = %q", dialer.TLSConfig.ServerName)
+	}
+}
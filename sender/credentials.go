@@ -0,0 +1,173 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CredentialProvider returns the username and password used to authenticate
+// to an SMTP server. It is called each time a Dialer dials host, so that
+// rotated passwords are used by the new connections without a restart.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string) (username, password string, err error)
+}
+
+// StaticCredentials is a CredentialProvider returning fixed credentials.
+type StaticCredentials struct {
+	Username string
+	Password string
+}
+
+// NewStaticCredentials returns a CredentialProvider returning the given
+// credentials.
+func NewStaticCredentials(username, password string) *StaticCredentials {
+	return &StaticCredentials{Username: username, Password: password}
+}
+
+// Credentials implements CredentialProvider.
+func (creds *StaticCredentials) Credentials(ctx context.Context, host string) (string, string, error) {
+	return creds.Username, creds.Password, nil
+}
+
+// EnvCredentials is a CredentialProvider reading the credentials from
+// environment variables each time it is called.
+type EnvCredentials struct {
+	// UsernameVar is the variable holding the username. When it is empty,
+	// no username is returned.
+	UsernameVar string
+	// PasswordVar is the variable holding the password. It must be set.
+	PasswordVar string
+}
+
+// NewEnvCredentials returns a CredentialProvider reading the username and the
+// password from the given environment variables.
+func NewEnvCredentials(usernameVar, passwordVar string) *EnvCredentials {
+	return &EnvCredentials{UsernameVar: usernameVar, PasswordVar: passwordVar}
+}
+
+// Credentials implements CredentialProvider.
+func (creds *EnvCredentials) Credentials(ctx context.Context, host string) (string, string, error) {
+	password, ok := os.LookupEnv(creds.PasswordVar)
+	if !ok {
+		return "", "", fmt.Errorf("m-mail: environment variable %s is not set", creds.PasswordVar)
+	}
+
+	var username string
+	if creds.UsernameVar != "" {
+		username = os.Getenv(creds.UsernameVar)
+	}
+	return username, password, nil
+}
+
+// FileCredentials is a CredentialProvider reading the password from a file,
+// such as a mounted secret. The file is read again when its size or
+// modification time changes. Its trailing newline is ignored.
+type FileCredentials struct {
+	Username string
+	Path     string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	size     int64
+	password string
+}
+
+// NewFileCredentials returns a CredentialProvider returning username and the
+// password read from the file at path.
+func NewFileCredentials(username, path string) *FileCredentials {
+	return &FileCredentials{Username: username, Path: path}
+}
+
+// Credentials implements CredentialProvider.
+func (creds *FileCredentials) Credentials(ctx context.Context, host string) (string, string, error) {
+	info, err := os.Stat(creds.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("m-mail: unable to read password file: %v", err)
+	}
+
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+
+	if !info.ModTime().Equal(creds.modTime) || info.Size() != creds.size {
+		data, err := os.ReadFile(creds.Path)
+		if err != nil {
+			return "", "", fmt.Errorf("m-mail: unable to read password file: %v", err)
+		}
+		creds.password = strings.TrimRight(string(data), "\r\n")
+		creds.modTime, creds.size = info.ModTime(), info.Size()
+	}
+
+	return creds.Username, creds.password, nil
+}
+
+// HelperCredentials is a CredentialProvider running a helper command that
+// speaks the protocol of the git credential helpers. The command is run with
+// the "get" argument and reads
+//
+//	protocol=smtp
+//	host=smtp.example.com
+//	username=alerts
+//
+// on its standard input, the username line being sent only when Username is
+// set. It must print the credentials as "username=..." and "password=..."
+// lines on its standard output.
+type HelperCredentials struct {
+	Command  string
+	Args     []string
+	Username string
+}
+
+// NewHelperCredentials returns a CredentialProvider running the given helper
+// command.
+func NewHelperCredentials(command string, args ...string) *HelperCredentials {
+	return &HelperCredentials{Command: command, Args: args}
+}
+
+// Credentials implements CredentialProvider.
+func (creds *HelperCredentials) Credentials(ctx context.Context, host string) (string, string, error) {
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=smtp\nhost=%s\n", host)
+	if creds.Username != "" {
+		fmt.Fprintf(&input, "username=%s\n", creds.Username)
+	}
+	input.WriteString("\n")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, creds.Command, append(append([]string{}, creds.Args...), "get")...)
+	cmd.Stdin = &input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", "", fmt.Errorf("m-mail: credential helper %s failed: %v: %s", creds.Command, err, msg)
+		}
+		return "", "", fmt.Errorf("m-mail: credential helper %s failed: %v", creds.Command, err)
+	}
+
+	username, password, hasPassword := creds.Username, "", false
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "username":
+			username = fields[1]
+		case "password":
+			password, hasPassword = fields[1], true
+		}
+	}
+	if !hasPassword {
+		return "", "", fmt.Errorf("m-mail: credential helper %s returned no password", creds.Command)
+	}
+
+	return username, password, nil
+}
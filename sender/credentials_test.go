@@ -0,0 +1,112 @@
+package sender
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds := NewFileCredentials("alerts", path)
+	username, password, err := creds.Credentials(context.Background(), "smtp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alerts" || password != "first" {
+		t.Fatalf("got %q and %q", username, password)
+	}
+
+	// The rotated password is read again, even when its modification time is
+	// unchanged thanks to its size.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("rotated\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, password, err = creds.Credentials(context.Background(), "smtp.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if password != "rotated" {
+		t.Fatalf("got %q after the rotation", password)
+	}
+
+	// A password of the same size is read again when the file is modified.
+	if err := os.WriteFile(path, []byte("changed\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, password, err = creds.Credentials(context.Background(), "smtp.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if password != "changed" {
+		t.Fatalf("got %q after the modification", password)
+	}
+
+	os.Remove(path)
+	if _, _, err := creds.Credentials(context.Background(), "smtp.example.com"); err == nil {
+		t.Fatal("no error for a missing file")
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	creds := NewEnvCredentials("MMAIL_TEST_USERNAME", "MMAIL_TEST_PASSWORD")
+	if _, _, err := creds.Credentials(context.Background(), "smtp.example.com"); err == nil {
+		t.Fatal("no error for a missing variable")
+	}
+
+	setenv(t, map[string]string{"MMAIL_TEST_USERNAME": "alerts", "MMAIL_TEST_PASSWORD": "secret"})
+	username, password, err := creds.Credentials(context.Background(), "smtp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alerts" || password != "secret" {
+		t.Fatalf("got %q and %q", username, password)
+	}
+}
+
+func TestHelperCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	helper := filepath.Join(dir, "helper")
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = get ] || exit 1\n" +
+		"cat > " + filepath.Join(dir, "input") + "\n" +
+		"echo username=alerts\n" +
+		"echo password=secret\n"
+	if err := os.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	creds := NewHelperCredentials(helper)
+	username, password, err := creds.Credentials(context.Background(), "smtp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alerts" || password != "secret" {
+		t.Fatalf("got %q and %q", username, password)
+	}
+
+	input, _ := os.ReadFile(filepath.Join(dir, "input"))
+	if string(input) != "protocol=smtp\nhost=smtp.example.com\n\n" {
+		t.Errorf("unexpected input %q", input)
+	}
+}
@@ -0,0 +1,116 @@
+package sender_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+// redirect makes dialer connect to srv whatever its address, and returns the
+// network and address dialed.
+func redirect(dialer *sender.Dialer, srv *smtptest.Server) (network, address *string) {
+	network, address = new(string), new(string)
+	dialer.DialContextFunc = func(ctx context.Context, n, addr string) (net.Conn, error) {
+		*network, *address = n, addr
+		return (&net.Dialer{}).DialContext(ctx, "tcp", srv.Addr)
+	}
+	return network, address
+}
+
+func TestDialIPv6Address(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	dialer := sender.NewDialer("::1", srv.Port, "", "")
+	_, address := redirect(dialer, srv)
+
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if want := "[::1]:" + strconv.Itoa(srv.Port); *address != want {
+		t.Errorf("dialed %q, want %q", *address, want)
+	}
+}
+
+func TestDialUnixSocket(t *testing.T) {
+	srv := smtptest.NewTLSServer()
+	defer srv.Close()
+
+	newDialer := func() *sender.Dialer {
+		dialer := sender.NewDialer("/run/smtp.sock", 0, "alice", "secret")
+		dialer.Network = "unix"
+		network, address := redirect(dialer, srv)
+		t.Cleanup(func() {
+			if *network != "" && (*network != "unix" || *address != "/run/smtp.sock") {
+				t.Errorf("dialed %s %s", *network, *address)
+			}
+		})
+		return dialer
+	}
+	send := func(dialer *sender.Dialer) *smtptest.Transaction {
+		t.Helper()
+		srv.Reset()
+		conn, err := dialer.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Send(newTestMessage("bob@example.com")); err != nil {
+			t.Fatal(err)
+		}
+		return srv.Transactions()[0]
+	}
+
+	// Without a server name, the socket is used without TLS, and PLAIN is
+	// allowed as with a local server.
+	tx := send(newDialer())
+	if tx.TLS || tx.Username != "alice" || tx.Password != "secret" {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+
+	dialer := newDialer()
+	dialer.StartTLSPolicy = sender.MandatoryStartTLS
+	if _, err := dialer.Dial(); err == nil {
+		t.Error("TLS was required without a server name")
+	}
+	dialer = newDialer()
+	dialer.SSL = true
+	if _, err := dialer.Dial(); err == nil {
+		t.Error("SSL was used without a server name")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	dialer = newDialer()
+	dialer.StartTLSPolicy = sender.MandatoryStartTLS
+	dialer.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if tx := send(dialer); !tx.TLS || tx.Username != "alice" {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+}
+
+func TestDialCredentials(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	dialer := srv.Dialer()
+	dialer.Credentials = sender.NewStaticCredentials("alice", "rotated")
+
+	conn := dial(t, dialer)
+	defer conn.Close()
+	if _, err := conn.Send(newTestMessage("bob@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if tx := srv.Transactions()[0]; tx.Username != "alice" || tx.Password != "rotated" {
+		t.Errorf("authenticated as %q with %q", tx.Username, tx.Password)
+	}
+}
@@ -0,0 +1,230 @@
+package sender
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/suppression"
+)
+
+// An LMTPDialer is a dialer to an LMTP server, as defined in RFC 2033, such as
+// the delivery service of Dovecot or Cyrus.
+type LMTPDialer struct {
+	// Network is the network of the server, "tcp" or "unix".
+	Network string
+	// Address is the host and port of the server, or the path of its socket.
+	Address string
+	// LocalName is the hostname sent to the server with the LHLO command.
+	// By default, "localhost" is sent.
+	LocalName string
+	// Suppressions is the list of the addresses that must not receive emails.
+	Suppressions suppression.Store
+}
+
+type lmtpSender struct {
+	text *textproto.Conn
+	d    *LMTPDialer
+	// closed is set once the connection is closed after an error leaving it
+	// in an unknown state, such as a message failing to write during DATA.
+	closed bool
+}
+
+var errLMTPClosed = errors.New("m-mail: the LMTP connection was closed after an error")
+
+// NewLMTPDialer returns a new LMTP dialer to the server listening on the given
+// network, "tcp" or "unix", and address.
+func NewLMTPDialer(network, address string) *LMTPDialer {
+	return &LMTPDialer{Network: network, Address: address}
+}
+
+// Dial dials an LMTP server and greets it. The returned SendCloser should be
+// closed when done using it.
+func (dialer *LMTPDialer) Dial() (SendCloser, error) {
+	conn, err := net.Dial(dialer.Network, dialer.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		text.Close()
+		return nil, err
+	}
+
+	localName := dialer.LocalName
+	if localName == "" {
+		localName = "localhost"
+	}
+	if _, err := textCmd(text, 250, "LHLO %s", localName); err != nil {
+		text.Close()
+		return nil, err
+	}
+
+	return &lmtpSender{text: text, d: dialer}, nil
+}
+
+// Send delivers the message to its recipients. A message that is not
+// personalized is delivered in a single transaction, the server replying for
+// each recipient once the message is sent. Recipients found in the suppression
+// list of the dialer are skipped, and the ones rejected by the server are
+// reported as failed, without stopping the others.
+func (sender *lmtpSender) Send(msg *message.Message) (*Result, error) {
+	from, err := msg.GetFrom()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+	if sender.closed {
+		return nil, errLMTPClosed
+	}
+
+	result := &Result{}
+	var recipients []string
+	for _, addr := range to {
+		if entry, err := suppressed(sender.d.Suppressions, addr, msg.GetCategory()); err != nil {
+			return result, err
+		} else if entry != nil {
+			result.add(RecipientResult{Address: addr, Status: Suppressed, Reason: entry.Reason})
+			continue
+		}
+		recipients = append(recipients, addr)
+	}
+
+	if !msg.IsPersonalized() {
+		return result, sender.send(result, from, recipients, msg)
+	}
+	for _, addr := range recipients {
+		if err := sender.send(result, from, []string{addr}, recipientCopy{msg, addr}); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+//Send a single transaction and add the outcome of its recipients to result.
+//The recipients left without a reply when the transaction fails are reported as
+//failed with its error. When the server rejects a command, the transaction is
+//reset so that the connection can be used for the next one. The connection is
+//closed on any other error, since the server may then be waiting for the rest
+//of the message or have replies left unread.
+func (sender *lmtpSender) send(result *Result, from string, to []string, msg io.WriterTo) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	start := len(result.Recipients)
+	err := sender.transaction(result, from, to, msg)
+	if err == nil {
+		return nil
+	}
+
+	replied := make(map[string]bool, len(to))
+	for _, recipient := range result.Recipients[start:] {
+		replied[recipient.Address] = true
+	}
+	for _, addr := range to {
+		if !replied[addr] {
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+		}
+	}
+
+	if _, ok := err.(*textproto.Error); !ok {
+		sender.abort()
+		return err
+	}
+	if _, err := textCmd(sender.text, 250, "RSET"); err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			sender.abort()
+		}
+		return err
+	}
+	return nil
+}
+
+//Run the commands of a transaction
+func (sender *lmtpSender) transaction(result *Result, from string, to []string, msg io.WriterTo) error {
+	if _, err := textCmd(sender.text, 250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+
+	var accepted []string
+	for _, addr := range to {
+		if _, err := textCmd(sender.text, 25, "RCPT TO:<%s>", addr); err != nil {
+			if _, ok := err.(*textproto.Error); !ok {
+				return err
+			}
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+			continue
+		}
+		accepted = append(accepted, addr)
+	}
+	if len(accepted) == 0 {
+		_, err := textCmd(sender.text, 250, "RSET")
+		return err
+	}
+
+	if _, err := textCmd(sender.text, 354, "DATA"); err != nil {
+		return err
+	}
+	w := sender.text.DotWriter()
+	if _, err := msg.WriteTo(w); err != nil {
+		// Closing w would end the message and have it delivered truncated.
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	// The server replies once for each accepted recipient.
+	for _, addr := range accepted {
+		if _, _, err := sender.text.ReadResponse(250); err != nil {
+			if _, ok := err.(*textproto.Error); !ok {
+				return err
+			}
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+			continue
+		}
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
+	return nil
+}
+
+//Close the connection without ending the current command, so that the server
+//discards the transaction
+func (sender *lmtpSender) abort() {
+	sender.closed = true
+	sender.text.Close()
+}
+
+// Close sends the QUIT command and closes the connection.
+func (sender *lmtpSender) Close() error {
+	if sender.closed {
+		return nil
+	}
+	sender.closed = true
+	textCmd(sender.text, 221, "QUIT")
+	return sender.text.Close()
+}
+
+//Send a command over text and read its reply
+func textCmd(text *textproto.Conn, expectCode int, format string, args ...interface{}) (string, error) {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	_, msg, err := text.ReadResponse(expectCode)
+	return msg, err
+}
@@ -0,0 +1,236 @@
+package sender_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+)
+
+// lmtpTransaction is a message delivered by an lmtpServer.
+type lmtpTransaction struct {
+	to   []string
+	data string
+}
+
+// lmtpServer is an LMTP server for tests. It rejects the MAIL commands of the
+// addresses containing "rejected", the RCPT commands of the ones containing
+// "unknown", the DATA command when a recipient contains "nodata" and the
+// delivery to the ones containing "full".
+type lmtpServer struct {
+	path     string
+	listener net.Listener
+
+	mu           sync.Mutex
+	transactions []lmtpTransaction
+}
+
+func newLMTPServer(t *testing.T) *lmtpServer {
+	t.Helper()
+
+	srv := &lmtpServer{path: filepath.Join(t.TempDir(), "lmtp.sock")}
+	l, err := net.Listen("unix", srv.path)
+	if err != nil {
+		t.Skip("unix sockets are not supported:", err)
+	}
+	srv.listener = l
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(textproto.NewConn(conn))
+		}
+	}()
+	return srv
+}
+
+func (srv *lmtpServer) serve(text *textproto.Conn) {
+	defer text.Close()
+
+	text.PrintfLine("220 localhost LMTP")
+	var to []string
+	open := false
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(line, "LHLO "):
+			text.PrintfLine("250-localhost\r\n250 PIPELINING")
+		case strings.HasPrefix(line, "MAIL FROM:"):
+			if open {
+				text.PrintfLine("503 5.5.1 Nested MAIL command")
+				continue
+			}
+			if strings.Contains(line, "rejected") {
+				text.PrintfLine("550 5.7.1 Sender rejected")
+				continue
+			}
+			to, open = nil, true
+			text.PrintfLine("250 2.1.0 OK")
+		case strings.HasPrefix(line, "RCPT TO:"):
+			addr := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if strings.Contains(addr, "unknown") {
+				text.PrintfLine("550 5.1.1 Unknown user")
+				continue
+			}
+			to = append(to, addr)
+			text.PrintfLine("250 2.1.5 OK")
+		case line == "DATA":
+			if len(to) > 0 && strings.Contains(to[0], "nodata") {
+				text.PrintfLine("554 5.6.0 Message rejected")
+				continue
+			}
+			open = false
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.transactions = append(srv.transactions, lmtpTransaction{to: to, data: string(data)})
+			srv.mu.Unlock()
+			for _, addr := range to {
+				if strings.Contains(addr, "full") {
+					text.PrintfLine("452 4.2.2 Mailbox full")
+				} else {
+					text.PrintfLine("250 2.0.0 Delivered")
+				}
+			}
+		case line == "RSET":
+			to, open = nil, false
+			text.PrintfLine("250 2.0.0 OK")
+		case line == "QUIT":
+			text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			text.PrintfLine("500 5.5.2 Unknown command")
+		}
+	}
+}
+
+func (srv *lmtpServer) Transactions() []lmtpTransaction {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return append([]lmtpTransaction(nil), srv.transactions...)
+}
+
+func TestLMTPSend(t *testing.T) {
+	srv := newLMTPServer(t)
+	s, err := sender.NewLMTPDialer("unix", srv.path).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	result, err := s.Send(newTestMessage("bob@example.com", "unknown@example.com", "full@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 1 || sent[0] != "bob@example.com" {
+		t.Errorf("unexpected result %v", result)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 2 {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 || len(transactions[0].to) != 2 {
+		t.Fatalf("the message was not sent in a single transaction: %v", transactions)
+	}
+
+	// The connection is still in sync once a personalized message is sent
+	// with a transaction per recipient.
+	msg := newTestMessage()
+	msg.SetRecipients(message.Recipient{Address: "bob@example.com"}, message.Recipient{Address: "carol@example.com"})
+	if result, err = s.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 2 {
+		t.Errorf("unexpected result %v", result)
+	}
+	if transactions := srv.Transactions(); len(transactions) != 3 {
+		t.Fatalf("got %d transactions, want 3", len(transactions))
+	}
+}
+
+func TestLMTPSendRejected(t *testing.T) {
+	srv := newLMTPServer(t)
+	s, err := sender.NewLMTPDialer("unix", srv.path).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	msg := newTestMessage("bob@example.com", "carol@example.com")
+	msg.SetHeader("From", "rejected@example.com")
+	result, err := s.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 2 {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	// The transaction is reset once the server rejects the message, and the
+	// following recipients of a personalized message are still sent it.
+	msg = newTestMessage()
+	msg.SetRecipients(message.Recipient{Address: "nodata@example.com"}, message.Recipient{Address: "bob@example.com"})
+	if result, err = s.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 1 || failed[0] != "nodata@example.com" {
+		t.Errorf("unexpected result %v", result)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 1 || sent[0] != "bob@example.com" {
+		t.Errorf("unexpected result %v", result)
+	}
+	if transactions := srv.Transactions(); len(transactions) != 1 || transactions[0].to[0] != "bob@example.com" {
+		t.Fatalf("unexpected transactions %v", transactions)
+	}
+}
+
+func TestLMTPSendAbortsTruncatedMessage(t *testing.T) {
+	srv := newLMTPServer(t)
+	s, err := sender.NewLMTPDialer("unix", srv.path).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	copyErr := errors.New("disk failure")
+	msg := newTestMessage("bob@example.com")
+	msg.Attach("report.pdf", message.SetCopyFunc(func(w io.Writer) error {
+		if _, err := w.Write(make([]byte, 64*1024)); err != nil {
+			return err
+		}
+		return copyErr
+	}))
+
+	if _, err := s.Send(msg); err == nil || !strings.Contains(err.Error(), copyErr.Error()) {
+		t.Fatalf("Send returned %v, want the copy error", err)
+	}
+	if _, err := s.Send(newTestMessage("bob@example.com")); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("Send returned %v on the aborted connection", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if transactions := srv.Transactions(); len(transactions) != 0 {
+		t.Fatalf("the truncated message was delivered: %v", transactions)
+	}
+}
@@ -0,0 +1,229 @@
+package sender
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Logger is the interface of the loggers of Dialer and Pool. It is
+// implemented by *slog.Logger. The arguments following the message are
+// alternating keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+//Log the outcome of a Send for each recipient, and its error
+func (dialer *Dialer) logResult(result *Result, err error) {
+	if dialer.Logger == nil {
+		return
+	}
+	logger, host := dialer.Logger, dialer.Host
+
+	if err != nil {
+		logger.Error("m-mail: send failed", "host", host, "error", err)
+	}
+	if result == nil {
+		return
+	}
+	for _, recipient := range result.Recipients {
+		switch recipient.Status {
+		case Sent:
+			logger.Info("m-mail: message sent", "host", host, "to", recipient.Address)
+		case Suppressed:
+			logger.Info("m-mail: recipient suppressed", "host", host, "to", recipient.Address,
+				"reason", string(recipient.Reason))
+		case Failed:
+			logger.Warn("m-mail: recipient rejected", "host", host, "to", recipient.Address,
+				"error", recipient.Err)
+		}
+	}
+}
+
+// transcript logs the SMTP commands and replies of a connection at the debug
+// level. The payloads of the AUTH command and the content of the messages are
+// redacted.
+type transcript struct {
+	logger Logger
+	host   string
+
+	mu     sync.Mutex
+	client []byte
+	server []byte
+	// phase is incremented when the connection switches to TLS, which makes
+	// the streams of the previous phases stop logging.
+	phase       int
+	auth        bool
+	dataPending bool
+	data        bool
+	dataBytes   int
+	tlsPending  bool
+}
+
+func newTranscript(logger Logger, host string) *transcript {
+	return &transcript{logger: logger, host: host}
+}
+
+//Record bytes written by the client or read from the server during phase
+func (t *transcript) record(fromClient bool, p []byte, phase int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if phase != t.phase {
+		return
+	}
+
+	buf := &t.server
+	if fromClient {
+		buf = &t.client
+	}
+	*buf = append(*buf, p...)
+
+	for {
+		index := bytes.IndexByte(*buf, '\n')
+		if index < 0 {
+			return
+		}
+		line := strings.TrimSuffix(string((*buf)[:index]), "\r")
+		*buf = (*buf)[index+1:]
+
+		if fromClient {
+			t.clientLine(line)
+		} else {
+			t.serverLine(line)
+		}
+		if phase != t.phase {
+			// The rest is the TLS handshake.
+			*buf = nil
+			return
+		}
+	}
+}
+
+func (t *transcript) clientLine(line string) {
+	if t.data {
+		if line == "." {
+			t.data = false
+			t.logger.Debug("m-mail: smtp", "host", t.host,
+				"client", "[message content redacted, "+strconv.Itoa(t.dataBytes)+" bytes]")
+			t.logger.Debug("m-mail: smtp", "host", t.host, "client", ".")
+			t.dataBytes = 0
+			return
+		}
+		t.dataBytes += len(line) + 2
+		return
+	}
+
+	if t.auth {
+		t.logger.Debug("m-mail: smtp", "host", t.host, "client", "[redacted]")
+		return
+	}
+
+	fields := strings.Fields(line)
+	command := ""
+	if len(fields) > 0 {
+		command = strings.ToUpper(fields[0])
+	}
+	switch command {
+	case "AUTH":
+		t.auth = true
+		if len(fields) > 2 {
+			line = fields[0] + " " + fields[1] + " [redacted]"
+		}
+	case "DATA":
+		t.dataPending = true
+	case "STARTTLS":
+		t.tlsPending = true
+	}
+	t.logger.Debug("m-mail: smtp", "host", t.host, "client", line)
+}
+
+func (t *transcript) serverLine(line string) {
+	t.logger.Debug("m-mail: smtp", "host", t.host, "server", line)
+
+	// Only the last line of a multiline reply has a space after the code.
+	if len(line) > 3 && line[3] == '-' {
+		return
+	}
+	code := line
+	if len(code) > 3 {
+		code = code[:3]
+	}
+
+	if t.auth && code != "334" {
+		t.auth = false
+	}
+	if t.dataPending {
+		t.dataPending = false
+		t.data = code == "354"
+	}
+	if t.tlsPending {
+		t.tlsPending = false
+		if code == "220" {
+			t.phase++
+		}
+	}
+}
+
+// transcriptConn is a connection whose traffic is recorded in a transcript
+// until it switches to TLS.
+type transcriptConn struct {
+	net.Conn
+	t     *transcript
+	phase int
+}
+
+func (conn *transcriptConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	conn.t.record(false, p[:n], conn.phase)
+	return n, err
+}
+
+func (conn *transcriptConn) Write(p []byte) (int, error) {
+	n, err := conn.Conn.Write(p)
+	conn.t.record(true, p[:n], conn.phase)
+	return n, err
+}
+
+// transcriptStream records the traffic of a textproto.Conn in a transcript. It
+// is used once the connection switched to TLS, since the underlying connection
+// then carries encrypted data.
+type transcriptStream struct {
+	text  *textproto.Conn
+	t     *transcript
+	phase int
+}
+
+//Return a textproto.Conn recording the traffic of text in t
+func (t *transcript) wrap(text *textproto.Conn) *textproto.Conn {
+	t.mu.Lock()
+	phase := t.phase
+	t.mu.Unlock()
+
+	return textproto.NewConn(&transcriptStream{text: text, t: t, phase: phase})
+}
+
+func (stream *transcriptStream) Read(p []byte) (int, error) {
+	n, err := stream.text.R.Read(p)
+	stream.t.record(false, p[:n], stream.phase)
+	return n, err
+}
+
+func (stream *transcriptStream) Write(p []byte) (int, error) {
+	n, err := stream.text.W.Write(p)
+	if err == nil {
+		err = stream.text.W.Flush()
+	}
+	stream.t.record(true, p[:n], stream.phase)
+	return n, err
+}
+
+func (stream *transcriptStream) Close() error {
+	return stream.text.Close()
+}
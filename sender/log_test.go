@@ -0,0 +1,97 @@
+package sender
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records the messages logged, one line per message with its
+// arguments.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line := level + " " + msg
+	for _, arg := range args {
+		line += " " + fmt.Sprint(arg)
+	}
+	l.lines = append(l.lines, line)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+func (l *recordingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return strings.Join(l.lines, "\n")
+}
+
+func TestTranscriptRedaction(t *testing.T) {
+	logger := &recordingLogger{}
+	tr := newTranscript(logger, "smtp.example.com")
+	client := func(line string) { tr.record(true, []byte(line+"\r\n"), 0) }
+	server := func(line string) { tr.record(false, []byte(line+"\r\n"), 0) }
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	server("220 smtp.example.com ESMTP")
+	client("EHLO localhost")
+	server("250-smtp.example.com")
+	server("250 AUTH PLAIN LOGIN")
+
+	client("AUTH PLAIN " + encode("\x00alice\x00plain-secret"))
+	server("535 5.7.8 Authentication credentials invalid")
+
+	client("AUTH LOGIN")
+	server("334 " + encode("Username:"))
+	client(encode("alice"))
+	server("334 " + encode("Password:"))
+	client(encode("login-secret"))
+	server("235 2.7.0 Authentication succeeded")
+
+	client("MAIL FROM:<alice@example.com>")
+	server("250 2.1.0 OK")
+	client("RCPT TO:<bob@example.com>")
+	server("250 2.1.5 OK")
+	client("DATA")
+	server("354 Start mail input")
+	client("Subject: confidential")
+	client("")
+	client("body-secret")
+	client(".")
+	server("250 2.0.0 OK")
+	client("QUIT")
+
+	transcript := logger.String()
+	for _, secret := range []string{"plain-secret", encode("\x00alice\x00plain-secret"), encode("alice"),
+		"login-secret", encode("login-secret"), "confidential", "body-secret"} {
+		if strings.Contains(transcript, secret) {
+			t.Errorf("%q is not redacted:\n%s", secret, transcript)
+		}
+	}
+	for _, want := range []string{
+		"220 smtp.example.com ESMTP",
+		"AUTH PLAIN [redacted]",
+		"client AUTH LOGIN",
+		"client [redacted]",
+		"235 2.7.0 Authentication succeeded",
+		"MAIL FROM:<alice@example.com>",
+		"[message content redacted, 38 bytes]",
+		"client QUIT",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("%q is missing from the transcript:\n%s", want, transcript)
+		}
+	}
+}
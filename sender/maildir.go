@@ -0,0 +1,154 @@
+package sender
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// maildirDeliveries counts the deliveries of the process to make the names of
+// the delivered files unique.
+var maildirDeliveries uint64
+
+// MaildirSender is a SendCloser delivering the messages into a Maildir on the local
+// filesystem instead of sending them. Each recipient gets its own copy of the
+// message, rendered as it would be sent over SMTP. It is safe for concurrent
+// use.
+type MaildirSender struct {
+	// Dir is the directory of the Maildir, holding the tmp, new and cur
+	// directories.
+	Dir string
+	// Flags are the Maildir flags of the delivered messages, such as "S" for
+	// seen messages. Messages with flags are delivered to cur instead of new.
+	Flags string
+}
+
+// NewMaildirSender returns a MaildirSender delivering into dir, creating the
+// Maildir if it does not exist.
+func NewMaildirSender(dir string) (*MaildirSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirSender{Dir: dir}, nil
+}
+
+// Send delivers a copy of the message for each of its recipients.
+func (sender *MaildirSender) Send(msg *message.Message) (*Result, error) {
+	from, err := msg.GetFrom()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+
+	result := &Result{}
+	for _, addr := range to {
+		if err := sender.deliver(from, addr, msg); err != nil {
+			return result, err
+		}
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
+
+	return result, nil
+}
+
+// Close implements SendCloser. It does nothing since each delivery opens and
+// closes its own file.
+func (sender *MaildirSender) Close() error {
+	return nil
+}
+
+//Write the message for a recipient in tmp and move it to new, or cur if it has
+//flags
+func (sender *MaildirSender) deliver(from, to string, msg *message.Message) error {
+	name := maildirName()
+	tmpPath := filepath.Join(sender.Dir, "tmp", name)
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = writeDelivery(file, from, to, msg)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dest := filepath.Join(sender.Dir, "new", name)
+	if flags := maildirFlags(sender.Flags); flags != "" {
+		dest = filepath.Join(sender.Dir, "cur", name+":2,"+flags)
+	}
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+//Write the delivery headers followed by the message rendered for a recipient
+func writeDelivery(w io.Writer, from, to string, msg *message.Message) error {
+	if _, err := io.WriteString(w, "Return-Path: <"+from+">\r\nDelivered-To: "+to+"\r\n"); err != nil {
+		return err
+	}
+	_, err := msg.WriteToRecipient(w, to)
+	return err
+}
+
+//Return a unique file name, as recommended by the Maildir specification
+func maildirName() string {
+	now := time.Now()
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+
+	return strconv.FormatInt(now.Unix(), 10) +
+		".M" + strconv.Itoa(now.Nanosecond()/1000) +
+		"P" + strconv.Itoa(os.Getpid()) +
+		"Q" + strconv.FormatUint(atomic.AddUint64(&maildirDeliveries, 1), 10) +
+		"R" + hex.EncodeToString(random) +
+		"." + host
+}
+
+//Return the flags sorted and without duplicates, as the specification requires
+func maildirFlags(flags string) string {
+	seen := make(map[rune]bool)
+	var sorted []string
+	for _, flag := range flags {
+		if !seen[flag] {
+			seen[flag] = true
+			sorted = append(sorted, string(flag))
+		}
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, "")
+}
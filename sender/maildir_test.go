@@ -0,0 +1,51 @@
+package sender_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/sender"
+)
+
+func TestMaildirSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	var s sender.SendCloser
+	maildir, err := sender.NewMaildirSender(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = maildir
+	defer s.Close()
+
+	if _, err := s.Send(newTestMessage("bob@example.com", "carol@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	maildir.Flags = "SFS"
+	if _, err := s.Send(newTestMessage("bob@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	newFiles, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	curFiles, _ := filepath.Glob(filepath.Join(dir, "cur", "*"))
+	tmpFiles, _ := filepath.Glob(filepath.Join(dir, "tmp", "*"))
+	if len(newFiles) != 2 || len(curFiles) != 1 || len(tmpFiles) != 0 {
+		t.Fatalf("got new %v, cur %v and tmp %v", newFiles, curFiles, tmpFiles)
+	}
+	if !strings.HasSuffix(curFiles[0], ":2,FS") {
+		t.Errorf("flags not sorted in %s", curFiles[0])
+	}
+
+	data, err := os.ReadFile(curFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "Return-Path: <alice@example.com>\r\nDelivered-To: bob@example.com\r\n") {
+		t.Errorf("missing delivery headers:\n%s", data)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,68 @@
+package sender
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ishail/m-mail/mbox"
+	"github.com/ishail/m-mail/message"
+)
+
+// MboxSender is a SendCloser appending the messages to an mbox file instead of
+// sending them. Each recipient gets its own copy of the message, rendered as it
+// would be sent over SMTP. It is safe for concurrent use.
+type MboxSender struct {
+	file *mbox.File
+}
+
+// recipientCopy is the rendering of a message for one of its recipients.
+type recipientCopy struct {
+	msg *message.Message
+	to  string
+}
+
+func (c recipientCopy) WriteTo(w io.Writer) (int64, error) {
+	return c.msg.WriteToRecipient(w, c.to)
+}
+
+// NewMboxSender returns an MboxSender appending to the mbox file at path,
+// creating it if it does not exist.
+func NewMboxSender(path string) (*MboxSender, error) {
+	file, err := mbox.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MboxSender{file: file}, nil
+}
+
+// Send appends a copy of the message for each of its recipients.
+func (sender *MboxSender) Send(msg *message.Message) (*Result, error) {
+	from, err := msg.GetFrom()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+
+	result := &Result{}
+	for _, addr := range to {
+		if err := sender.file.WriteMessage(from, time.Now(), recipientCopy{msg, addr}); err != nil {
+			return result, err
+		}
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
+
+	return result, nil
+}
+
+// Close closes the mbox file.
+func (sender *MboxSender) Close() error {
+	return sender.file.Close()
+}
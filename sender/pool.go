@@ -0,0 +1,224 @@
+package sender
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/ishail/m-mail/message"
+)
+
+var errPoolClosed = errors.New("m-mail: pool is closed")
+
+// A Pool is a pool of connections to an SMTP server. Each call to Send uses an
+// idle connection of the pool, or dials a new one when all of them are busy and
+// the pool is not full. It is safe for concurrent use and implements
+// SendCloser.
+type Pool struct {
+	// Retry is the policy applied when Send fails with a temporary error. By
+	// default, Send is not retried.
+	Retry RetryPolicy
+	// Logger receives the retries of the pool. By default, the Logger of the
+	// dialer is used.
+	Logger Logger
+
+	dialer *Dialer
+	slots  chan struct{}
+	// done is closed by Close, interrupting the backoffs of Send.
+	done chan struct{}
+
+	mu     sync.Mutex
+	idle   []SendCloser
+	closed bool
+}
+
+// NewPool returns a Pool of at most size connections dialed with dialer. A size
+// lower than 1 is treated as 1.
+func NewPool(dialer *Dialer, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{
+		dialer: dialer,
+		slots:  make(chan struct{}, size),
+		done:   make(chan struct{}),
+	}
+}
+
+// Send sends the message over one of the connections of the pool, blocking
+// until one is available. A connection is discarded when Send returns an error,
+// and the message is sent again over another one as defined by the Retry policy
+// of the pool. A message whose delivery is unconfirmed, the connection being
+// lost before the server acknowledged it, is not sent again. Close interrupts
+// the backoff between two attempts.
+func (pool *Pool) Send(msg *message.Message) (*Result, error) {
+	backoff := time.Duration(pool.Retry.Backoff)
+	for attempt := 1; ; attempt++ {
+		result, err := pool.send(msg)
+		if attempt >= pool.Retry.Attempts || !retryable(result, err) {
+			return result, err
+		}
+
+		if logger := pool.logger(); logger != nil {
+			logger.Warn("m-mail: retrying send", "host", pool.dialer.Host, "attempt", attempt+1,
+				"backoff", backoff, "error", retryCause(result, err))
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-pool.done:
+			timer.Stop()
+			return result, errPoolClosed
+		}
+		if pool.isClosed() {
+			return result, errPoolClosed
+		}
+		backoff *= 2
+		if maxBackoff := time.Duration(pool.Retry.MaxBackoff); maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+//Return the logger of the pool, or the one of its dialer
+func (pool *Pool) logger() Logger {
+	if pool.Logger != nil {
+		return pool.Logger
+	}
+	return pool.dialer.Logger
+}
+
+//Send the message over a connection of the pool, once
+func (pool *Pool) send(msg *message.Message) (*Result, error) {
+	pool.slots <- struct{}{}
+	defer func() { <-pool.slots }()
+
+	conn, err := pool.get()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Send(msg)
+	if err != nil {
+		conn.Close()
+		return result, err
+	}
+
+	pool.put(conn)
+	return result, nil
+}
+
+// Close closes the idle connections of the pool. Connections in use are closed
+// when they are released. The pool cannot be used after Close.
+func (pool *Pool) Close() error {
+	pool.mu.Lock()
+	idle := pool.idle
+	pool.idle = nil
+	if !pool.closed {
+		pool.closed = true
+		close(pool.done)
+	}
+	pool.mu.Unlock()
+
+	var firstErr error
+	for _, conn := range idle {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+//Report whether the pool was closed
+func (pool *Pool) isClosed() bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.closed
+}
+
+//Return an idle connection or dial a new one
+func (pool *Pool) get() (SendCloser, error) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil, errPoolClosed
+	}
+	if n := len(pool.idle); n > 0 {
+		conn := pool.idle[n-1]
+		pool.idle = pool.idle[:n-1]
+		pool.mu.Unlock()
+		return conn, nil
+	}
+	pool.mu.Unlock()
+
+	return pool.dialer.Dial()
+}
+
+//Release a connection, closing it if the pool was closed
+func (pool *Pool) put(conn SendCloser) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		conn.Close()
+		return
+	}
+	pool.idle = append(pool.idle, conn)
+	pool.mu.Unlock()
+}
+
+//Report whether a Send can be retried without sending the message twice to a
+//recipient: no recipient was sent the message and the failures are temporary.
+//An unconfirmed delivery is not temporary.
+func retryable(result *Result, err error) bool {
+	failed := false
+	if result != nil {
+		for _, recipient := range result.Recipients {
+			switch {
+			case recipient.Status == Sent:
+				return false
+			case recipient.Status == Failed && !temporary(recipient.Err):
+				return false
+			case recipient.Status == Failed:
+				failed = true
+			}
+		}
+	}
+
+	if err != nil {
+		return temporary(err)
+	}
+	return failed
+}
+
+//Report whether err is a lost connection or a 4xx reply of the server
+func temporary(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if protoErr, ok := err.(*textproto.Error); ok {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	return false
+}
+
+//Return the error causing a retry, err or the error of the first failed
+//recipient
+func retryCause(result *Result, err error) error {
+	if err != nil || result == nil {
+		return err
+	}
+	for _, recipient := range result.Recipients {
+		if recipient.Status == Failed {
+			return recipient.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sender
+
+import (
+	"errors"
+	"io"
+	"net/textproto"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tempFail := &textproto.Error{Code: 451, Msg: "4.3.0 Try again later"}
+	permFail := &textproto.Error{Code: 550, Msg: "5.1.1 Unknown user"}
+
+	for _, test := range []struct {
+		name      string
+		result    *Result
+		err       error
+		retryable bool
+	}{
+		{"sent", &Result{Recipients: []RecipientResult{{Status: Sent}}}, nil, false},
+		{"lost connection", nil, io.EOF, true},
+		{"temporary reply", nil, tempFail, true},
+		{"permanent reply", nil, permFail, false},
+		{"other error", nil, errors.New("invalid message"), false},
+		{"unconfirmed delivery", nil, &unconfirmedError{io.EOF}, false},
+		{"temporary failures", &Result{Recipients: []RecipientResult{
+			{Status: Failed, Err: tempFail},
+			{Status: Suppressed},
+		}}, nil, true},
+		{"permanent failure", &Result{Recipients: []RecipientResult{
+			{Status: Failed, Err: tempFail},
+			{Status: Failed, Err: permFail},
+		}}, nil, false},
+		{"partially sent", &Result{Recipients: []RecipientResult{
+			{Status: Sent},
+			{Status: Failed, Err: tempFail},
+		}}, io.EOF, false},
+	} {
+		if got := retryable(test.result, test.err); got != test.retryable {
+			t.Errorf("%s: retryable returned %v", test.name, got)
+		}
+	}
+}
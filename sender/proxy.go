@@ -0,0 +1,170 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc is the signature of Dialer.DialContextFunc.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dial implements proxy.Dialer so that a dialFunc can be used to reach a SOCKS5
+// proxy.
+func (dial dialFunc) Dial(network, address string) (net.Conn, error) {
+	return dial(context.Background(), network, address)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (dial dialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return dial(ctx, network, address)
+}
+
+// bufferedConn is a connection whose first bytes were read by a bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(p []byte) (int, error) {
+	return conn.r.Read(p)
+}
+
+//Return the url of the proxy of the dialer, from its ProxyURL or the
+//environment, or nil if the server is reached directly
+func (dialer *Dialer) proxyURL() (*url.URL, error) {
+	rawURL := dialer.ProxyURL
+	if rawURL == "" && dialer.ProxyFromEnvironment {
+		rawURL = getEnv("ALL_PROXY", "all_proxy")
+	}
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("m-mail: invalid proxy url %q", rawURL)
+	}
+	return proxyURL, nil
+}
+
+//Wrap forward so that it connects through the proxy of the dialer, if any
+func (dialer *Dialer) proxyDial(forward dialFunc) (dialFunc, error) {
+	proxyURL, err := dialer.proxyURL()
+	if err != nil || proxyURL == nil {
+		return forward, err
+	}
+
+	var dial dialFunc
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyAddr(proxyURL, "1080"), auth, forward)
+		if err != nil {
+			return nil, err
+		}
+		dial = socks.(proxy.ContextDialer).DialContext
+	case "http", "https":
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return httpConnect(ctx, proxyURL, forward, address)
+		}
+	default:
+		return nil, fmt.Errorf("m-mail: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+
+	if dialer.ProxyURL != "" {
+		return dial, nil
+	}
+
+	// Hosts listed in NO_PROXY are reached directly.
+	perHost := proxy.NewPerHost(dial, forward)
+	perHost.AddFromString(getEnv("NO_PROXY", "no_proxy"))
+	return perHost.DialContext, nil
+}
+
+//Open a tunnel to address with the CONNECT method of an HTTP proxy
+func httpConnect(ctx context.Context, proxyURL *url.URL, forward dialFunc, address string) (net.Conn, error) {
+	defaultPort := "80"
+	if proxyURL.Scheme == "https" {
+		defaultPort = "443"
+	}
+
+	conn, err := forward(ctx, "tcp", proxyAddr(proxyURL, defaultPort))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("m-mail: proxy refused to connect to %s: %s", address, resp.Status)
+	}
+
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+//Return the host and port of a proxy
+func proxyAddr(proxyURL *url.URL, defaultPort string) string {
+	if port := proxyURL.Port(); port != "" {
+		return proxyURL.Host
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), defaultPort)
+}
+
+//Return the value of the first environment variable set
+func getEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
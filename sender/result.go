@@ -0,0 +1,36 @@
+package sender
+
+import (
+	"strings"
+)
+
+//Add the outcome of a recipient
+func (result *Result) add(recipient RecipientResult) {
+	result.Recipients = append(result.Recipients, recipient)
+}
+
+// Addresses returns the addresses of the recipients with the given status.
+func (result *Result) Addresses(status Status) []string {
+	var addresses []string
+	for _, recipient := range result.Recipients {
+		if recipient.Status == status {
+			addresses = append(addresses, recipient.Address)
+		}
+	}
+	return addresses
+}
+
+// String returns a summary of the result, one recipient per line.
+func (result *Result) String() string {
+	lines := make([]string, len(result.Recipients))
+	for index, recipient := range result.Recipients {
+		lines[index] = recipient.Address + ": " + string(recipient.Status)
+		switch {
+		case recipient.Err != nil:
+			lines[index] += " (" + recipient.Err.Error() + ")"
+		case recipient.Reason != "":
+			lines[index] += " (" + string(recipient.Reason) + ")"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
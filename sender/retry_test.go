@@ -0,0 +1,92 @@
+package sender_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/server"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+var errTryLater = &server.Error{Code: 451, EnhancedCode: "4.3.0", Message: "Try again later"}
+
+func TestPoolRetry(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	var attempts int32
+	srv.OnMail = func(from string) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errTryLater
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	pool := sender.NewPool(srv.Dialer(), 1)
+	pool.Retry = sender.RetryPolicy{Attempts: 3, Backoff: sender.Duration(time.Millisecond)}
+	defer pool.Close()
+
+	result, err := pool.Send(newTestMessage("bob@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 1 || atomic.LoadInt32(&attempts) != 3 {
+		t.Fatalf("got %v after %d attempts", result, atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestPoolCloseInterruptsRetry(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	srv.OnMail = func(from string) error { return errTryLater }
+	srv.Start()
+	defer srv.Close()
+
+	pool := sender.NewPool(srv.Dialer(), 1)
+	pool.Retry = sender.RetryPolicy{Attempts: 3, Backoff: sender.Duration(time.Hour)}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pool.Close()
+	}()
+
+	done := make(chan error)
+	go func() {
+		_, err := pool.Send(newTestMessage("bob@example.com"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Send succeeded on a closed pool")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not interrupt the backoff")
+	}
+}
+
+func TestPoolDoesNotRetryUnconfirmedDelivery(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	var attempts int32
+	srv.OnMail = func(from string) error {
+		atomic.AddInt32(&attempts, 1)
+		return nil
+	}
+	// The server reads the whole message and drops the connection before
+	// replying, so it may have delivered it.
+	srv.DropAfterData = true
+	srv.Start()
+	defer srv.Close()
+
+	pool := sender.NewPool(srv.Dialer(), 1)
+	pool.Retry = sender.RetryPolicy{Attempts: 3, Backoff: sender.Duration(time.Millisecond)}
+	defer pool.Close()
+
+	if _, err := pool.Send(newTestMessage("bob@example.com")); err == nil {
+		t.Fatal("Send succeeded without a reply of the server")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("the message was sent %d times", n)
+	}
+}
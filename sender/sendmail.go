@@ -0,0 +1,197 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// Exit codes of sendmail, defined in sysexits.h, for which the recipient is
+// reported as failed instead of failing the whole Send.
+const (
+	exitDataErr = 65
+	exitNoUser  = 67
+	exitNoHost  = 68
+)
+
+// sysexits are the descriptions of the exit codes of sendmail.
+var sysexits = map[int]string{
+	64: "command line usage error",
+	65: "data format error",
+	66: "cannot open input",
+	67: "addressee unknown",
+	68: "host name unknown",
+	69: "service unavailable",
+	70: "internal software error",
+	71: "system error",
+	72: "critical OS file missing",
+	73: "can't create output file",
+	74: "input/output error",
+	75: "temporary failure",
+	76: "remote error in protocol",
+	77: "permission denied",
+	78: "configuration error",
+}
+
+// SendmailSender is a Sender piping the messages into a sendmail compatible
+// binary, such as the ones of Postfix or Exim. Each recipient gets its own copy
+// of the message, rendered as it would be sent over SMTP, and the command is run
+// once per recipient as:
+//
+//	Path Args... -f from -- recipient
+type SendmailSender struct {
+	// Path is the path of the binary, "/usr/sbin/sendmail" by default.
+	Path string
+	// Args are the arguments passed before the sender and the recipient. They
+	// default to "-i" so that lines with a single dot do not end the message.
+	Args []string
+}
+
+// A SendmailError is returned when the sendmail binary exits with an error.
+type SendmailError struct {
+	// Code is the exit code of the binary.
+	Code int
+	// Stderr is what the binary wrote on its standard error.
+	Stderr string
+}
+
+func (err *SendmailError) Error() string {
+	msg := fmt.Sprintf("m-mail: sendmail exited with status %d", err.Code)
+	if desc, ok := sysexits[err.Code]; ok {
+		msg += " (" + desc + ")"
+	}
+	if err.Stderr != "" {
+		msg += ": " + err.Stderr
+	}
+	return msg
+}
+
+// NewSendmailSender returns a SendmailSender running the binary at path with the
+// default arguments.
+func NewSendmailSender(path string) *SendmailSender {
+	return &SendmailSender{Path: path}
+}
+
+// Send pipes a copy of the message for each of its recipients into sendmail.
+// Recipients rejected by sendmail, as an unknown user or host or invalid data,
+// are reported as failed, without stopping the others.
+func (sender *SendmailSender) Send(msg *message.Message) (*Result, error) {
+	from, err := msg.GetFrom()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := msg.GetRecipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+
+	result := &Result{}
+	for _, addr := range to {
+		if err := sender.run(from, addr, msg); err != nil {
+			sendmailErr, ok := err.(*SendmailError)
+			if !ok || (sendmailErr.Code != exitDataErr && sendmailErr.Code != exitNoUser &&
+				sendmailErr.Code != exitNoHost) {
+				return result, err
+			}
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+			continue
+		}
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
+
+	return result, nil
+}
+
+//Run sendmail for a recipient, piping the message rendered for it
+func (sender *SendmailSender) run(from, to string, msg *message.Message) error {
+	path := sender.Path
+	if path == "" {
+		path = "/usr/sbin/sendmail"
+	}
+	args := sender.Args
+	if args == nil {
+		args = []string{"-i"}
+	}
+	args = append(append([]string{}, args...), "-f", from, "--", to)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("m-mail: unable to run sendmail: %v", err)
+	}
+
+	lf := &lfWriter{w: stdin}
+	w := bufio.NewWriter(lf)
+	_, writeErr := msg.WriteToRecipient(w, to)
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+	// Kill the binary before closing its input, otherwise it would take the end
+	// of the input for the end of the message and deliver it truncated. A
+	// binary that closed its input, when it rejects the recipient for
+	// instance, has stopped reading the message and its exit status explains
+	// the write error better.
+	if writeErr != nil && !errors.Is(lf.err, syscall.EPIPE) {
+		cmd.Process.Kill()
+		stdin.Close()
+		cmd.Wait()
+		return writeErr
+	}
+	stdin.Close()
+
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return &SendmailError{
+				Code:   exitErr.ExitCode(),
+				Stderr: strings.TrimSpace(stderr.String()),
+			}
+		}
+		return err
+	}
+	return writeErr
+}
+
+// lfWriter converts the CRLF line endings of a message to LF, which sendmail
+// expects as it reads a message from its standard input.
+type lfWriter struct {
+	w  io.Writer
+	cr bool
+	// err is the error of w, which the message may not return as is.
+	err error
+}
+
+func (w *lfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+1)
+	for _, c := range p {
+		if w.cr && c != '\n' {
+			out = append(out, '\r')
+		}
+		w.cr = c == '\r'
+		if !w.cr {
+			out = append(out, c)
+		}
+	}
+
+	if _, err := w.w.Write(out); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
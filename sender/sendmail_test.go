@@ -0,0 +1,104 @@
+package sender_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+)
+
+// sendmailScript writes a fake sendmail binary in dir. It rejects the
+// recipients containing "unknown" and writes its arguments and input to the
+// args and out files. The input is moved to out once it has been read
+// entirely, like a real sendmail only queues complete messages.
+func sendmailScript(t *testing.T, dir string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake sendmail is a shell script")
+	}
+
+	path := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\n" +
+		"for arg; do last=$arg; done\n" +
+		"case $last in *unknown*) echo 'no such user' >&2; exit 67;; esac\n" +
+		"echo \"$@\" >> " + dir + "/args\n" +
+		"cat > " + dir + "/input && cat " + dir + "/input >> " + dir + "/out\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSendmailSender(t *testing.T) {
+	dir := t.TempDir()
+	s := sender.NewSendmailSender(sendmailScript(t, dir))
+
+	result, err := s.Send(newTestMessage("bob@example.com", "unknown@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 1 || sent[0] != "bob@example.com" {
+		t.Errorf("unexpected result %v", result)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 1 || failed[0] != "unknown@example.com" {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "-i -f alice@example.com -- bob@example.com\n" {
+		t.Errorf("unexpected arguments %q", args)
+	}
+	out, _ := os.ReadFile(filepath.Join(dir, "out"))
+	if len(out) == 0 || strings.Contains(string(out), "\r") {
+		t.Errorf("message not written with LF line endings: %q", out)
+	}
+}
+
+func TestSendmailSenderRejectsBeforeReading(t *testing.T) {
+	dir := t.TempDir()
+	s := sender.NewSendmailSender(sendmailScript(t, dir))
+
+	// The message is larger than the pipe buffer, so writing it fails once
+	// sendmail exits without reading it.
+	msg := newTestMessage("unknown@example.com")
+	msg.Attach("report.pdf", message.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(make([]byte, 1024*1024))
+		return err
+	}))
+
+	result, err := s.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := result.Recipients[0]
+	if sendmailErr, ok := failed.Err.(*sender.SendmailError); failed.Status != sender.Failed || !ok || sendmailErr.Code != 67 {
+		t.Fatalf("unexpected result %v", result)
+	}
+}
+
+func TestSendmailSenderKillsOnWriteError(t *testing.T) {
+	dir := t.TempDir()
+	s := sender.NewSendmailSender(sendmailScript(t, dir))
+
+	copyErr := errors.New("disk failure")
+	msg := newTestMessage("bob@example.com")
+	msg.Attach("report.pdf", message.SetCopyFunc(func(w io.Writer) error {
+		if _, err := w.Write(make([]byte, 64*1024)); err != nil {
+			return err
+		}
+		return copyErr
+	}))
+
+	if _, err := s.Send(msg); err == nil || !strings.Contains(err.Error(), copyErr.Error()) {
+		t.Fatalf("Send returned %v, want the copy error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Fatal("the truncated message was delivered")
+	}
+}
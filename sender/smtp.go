@@ -4,14 +4,20 @@
 package sender
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/textproto"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ishail/m-mail/common"
 	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/suppression"
 	"github.com/ishail/smtp/smtp"
 )
 
@@ -30,64 +36,162 @@ func NewDialer(host string, port int, username, password string) *Dialer {
 // Dial dials and authenticates to an SMTP server. The returned SendCloser
 // should be closed when done using it.
 func (dialer *Dialer) Dial() (SendCloser, error) {
-	// conn, err := net.DialTimeout("tcp", common.HostPortAddr(dialer.Host, dialer.Port),
-	// 	10*time.Second)
-	conn, err := net.Dial("tcp", common.HostPortAddr(dialer.Host, dialer.Port))
+	return dialer.DialContext(context.Background())
+}
+
+// DialContext is like Dial but uses the given context to connect to the SMTP
+// server.
+func (dialer *Dialer) DialContext(ctx context.Context) (SendCloser, error) {
+	network, address := dialer.Network, dialer.Host
+	if network == "" {
+		network = "tcp"
+	}
+	unix := network == "unix"
+	if !unix {
+		address = net.JoinHostPort(dialer.Host, strconv.Itoa(dialer.Port))
+	}
+
+	// The path of a unix socket cannot be checked against the certificate of
+	// the server.
+	hasServerName := !unix || (dialer.TLSConfig != nil && dialer.TLSConfig.ServerName != "")
+	if !hasServerName && (dialer.SSL || dialer.StartTLSPolicy == MandatoryStartTLS) {
+		return nil, errors.New("m-mail: TLSConfig.ServerName is required to use TLS over a unix socket")
+	}
+
+	var dialContext dialFunc = dialer.DialContextFunc
+	if dialContext == nil {
+		dialContext = (&net.Dialer{}).DialContext
+	}
+	if !unix {
+		var err error
+		if dialContext, err = dialer.proxyDial(dialContext); err != nil {
+			return nil, err
+		}
+	}
+	if dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialer.Timeout)
+		defer cancel()
+	}
+	conn, err := dialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
+	if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+
+	// The transcript records the connection from the greeting of the server,
+	// above the TLS connection with SSL, or the textproto.Conn of the client
+	// once the connection switches to TLS with STARTTLS.
+	var t *transcript
+	if dialer.Logger != nil {
+		t = newTranscript(dialer.Logger, dialer.Host)
+	}
 
 	if dialer.SSL {
 		conn = tls.Client(conn, dialer.tlsConfig())
 	}
+	if t != nil {
+		conn = &transcriptConn{Conn: conn, t: t}
+	}
 
-	c, err := common.SmtpNewClient(conn, dialer.Host)
+	serverName := dialer.serverName()
+	c, err := common.SmtpNewClient(conn, serverName)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
 	if dialer.LocalName != "" {
 		if err := c.Hello(dialer.LocalName); err != nil {
+			c.Close()
 			return nil, err
 		}
 	}
 
-	if !dialer.SSL {
+	if !dialer.SSL && dialer.StartTLSPolicy != NoStartTLS && hasServerName {
 		if ok, _ := c.Extension("STARTTLS"); ok {
 			if err := c.StartTLS(dialer.tlsConfig()); err != nil {
 				c.Close()
 				return nil, err
 			}
+			if t != nil {
+				// The EHLO sent by StartTLS is encrypted before it can be
+				// recorded, so it is sent again on the recorded stream.
+				c.Text = t.wrap(c.Text)
+				localName := dialer.LocalName
+				if localName == "" {
+					localName = "localhost"
+				}
+				if _, err := textCmd(c.Text, 250, "EHLO %s", localName); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+		} else if dialer.StartTLSPolicy == MandatoryStartTLS {
+			c.Close()
+			return nil, errors.New("m-mail: the SMTP server does not support STARTTLS")
 		}
 	}
 
-	if dialer.Auth == nil && dialer.Username != "" {
+	// The mechanism is chosen for each connection so that a Dialer can be used
+	// concurrently, by a Pool for instance.
+	username, password := dialer.Username, dialer.Password
+	if dialer.Credentials != nil && dialer.Auth == nil {
+		if username, password, err = dialer.Credentials.Credentials(ctx, dialer.Host); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	auth := dialer.Auth
+	if auth == nil && username != "" {
 		if ok, auths := c.Extension("AUTH"); ok {
 			if strings.Contains(auths, "CRAM-MD5") {
-				dialer.Auth = smtp.CRAMMD5Auth(dialer.Username, dialer.Password)
+				auth = smtp.CRAMMD5Auth(username, password)
 			} else if strings.Contains(auths, "LOGIN") &&
 				!strings.Contains(auths, "PLAIN") {
-				dialer.Auth = &loginAuth{
-					username: dialer.Username,
-					password: dialer.Password,
-					host:     dialer.Host,
+				auth = &loginAuth{
+					username: username,
+					password: password,
+					host:     serverName,
 				}
 			} else {
-				dialer.Auth = smtp.PlainAuth("", dialer.Username, dialer.Password, dialer.Host)
+				auth = smtp.PlainAuth("", username, password, serverName)
 			}
 		}
 	}
 
-	if dialer.Auth != nil {
-		if err = c.Auth(dialer.Auth); err != nil {
+	if auth != nil {
+		if dialer.SSL && t != nil {
+			auth = &sslAuth{auth}
+		}
+		if err = c.Auth(auth); err != nil {
 			c.Close()
 			return nil, err
 		}
 	}
 
+	if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Time{})
+	}
 	return &smtpSender{*c, dialer}, nil
 }
 
+//Return the name of the server, used by TLS and the authentication. The path of
+//a unix socket is not a host name, so the ServerName of TLSConfig is used
+//instead, or "localhost" without TLS.
+func (dialer *Dialer) serverName() string {
+	if dialer.Network != "unix" {
+		return dialer.Host
+	}
+	if dialer.TLSConfig != nil && dialer.TLSConfig.ServerName != "" {
+		return dialer.TLSConfig.ServerName
+	}
+	return "localhost"
+}
+
 func (dialer *Dialer) tlsConfig() *tls.Config {
 	if dialer.TLSConfig == nil {
 		return &tls.Config{ServerName: dialer.Host}
@@ -95,64 +199,211 @@ func (dialer *Dialer) tlsConfig() *tls.Config {
 	return dialer.TLSConfig
 }
 
-// Close closes the connection.
+// Close sends the QUIT command and closes the connection. The connection is
+// closed even if the QUIT command fails.
 func (c *smtpSender) Close() error {
-	return c.Text.Close()
+	if err := c.Quit(); err != nil {
+		c.Client.Close()
+		return err
+	}
+	return nil
 }
 
-func (sender *smtpSender) Send(msg *message.Message) (string, error) {
-	defer sender.Close()
+// Send sends the message to each of its recipients in its own transaction, so
+// that each recipient gets its own rendering of the message. Recipients found in
+// the suppression list of the dialer are skipped, and the ones rejected by the
+// server are reported as failed, without stopping the others.
+func (sender *smtpSender) Send(msg *message.Message) (*Result, error) {
+	result, err := sender.sendMessage(msg)
+	sender.d.logResult(result, err)
+	return result, err
+}
+
+//Send the message to each of its recipients
+func (sender *smtpSender) sendMessage(msg *message.Message) (*Result, error) {
 	from, err := msg.GetFrom()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	to, err := msg.GetRecipients()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
 	}
 
+	result := &Result{}
 	for _, addr := range to {
-		msgByte := msg.GetEmailBytes(addr)
-
-		if err := sender.Mail(from); err != nil {
-			if err == io.EOF {
-				// This is probably due to a timeout, so reconnect and try again.
-				if sc, err := sender.d.Dial(); err != nil {
-					return "", fmt.Errorf("m-mail: unable to Dial! Error: %s", err.Error())
-				} else {
-					if s, ok := sc.(*smtpSender); ok {
-						*sender = *s
-					}
-				}
-			}
+		if entry, err := suppressed(sender.d.Suppressions, addr, msg.GetCategory()); err != nil {
+			return result, err
+		} else if entry != nil {
+			result.add(RecipientResult{Address: addr, Status: Suppressed, Reason: entry.Reason})
+			continue
 		}
 
-		if err := sender.Rcpt(addr); err != nil {
-			return "", err
+		if err := sender.send(from, addr, msg); err != nil {
+			if _, ok := err.(*textproto.Error); !ok {
+				return result, err
+			}
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+			if err := sender.Reset(); err != nil {
+				return result, err
+			}
+			continue
 		}
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
 
-		w, _, _, err := sender.Data()
-		if err != nil {
-			return "", err
+	return result, nil
+}
+
+//Send a single transaction, streaming the message rendered for the recipient
+func (sender *smtpSender) send(from, to string, msg *message.Message) error {
+	if err := sender.mail(from); err != nil {
+		return err
+	}
+
+	if err := sender.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, _, _, err := sender.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = msg.WriteToRecipient(w, to); err != nil {
+		sender.abort()
+		return err
+	}
+
+	return confirm(w.Close())
+}
+
+//Close the connection in the middle of the DATA command, so that the server
+//discards the partial message instead of delivering it. The sender cannot be
+//used afterwards.
+func (sender *smtpSender) abort() {
+	sender.Client.Close()
+}
+
+// unconfirmedError is returned when the connection fails once the whole message
+// is sent, before the server replies. The server may have accepted the message,
+// which must not be sent again.
+type unconfirmedError struct {
+	err error
+}
+
+func (err *unconfirmedError) Error() string {
+	return "m-mail: the delivery of the message is unconfirmed: " + err.err.Error()
+}
+
+func (err *unconfirmedError) Unwrap() error {
+	return err.err
+}
+
+//Return the error ending the data of a message, the ones other than a reply of
+//the server meaning that the delivery is unconfirmed
+func confirm(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*textproto.Error); ok {
+		return err
+	}
+	return &unconfirmedError{err}
+}
+
+// SendRaw sends a message that is already rendered to the given recipients, in
+// a single transaction. Recipients found in the suppression list of the dialer
+// are skipped, and the ones rejected by the server are reported as failed.
+func (sender *smtpSender) SendRaw(from string, to []string, r io.Reader) (*Result, error) {
+	result, err := sender.sendRaw(from, to, r)
+	sender.d.logResult(result, err)
+	return result, err
+}
+
+//Send the rendered message to the recipients in a single transaction
+func (sender *smtpSender) sendRaw(from string, to []string, r io.Reader) (*Result, error) {
+	if len(to) == 0 {
+		return nil, errors.New("m-mail: invalid message, no recipient!")
+	}
+	if err := sender.mail(from); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	var accepted []string
+	for _, addr := range to {
+		if entry, err := suppressed(sender.d.Suppressions, addr, ""); err != nil {
+			return result, err
+		} else if entry != nil {
+			result.add(RecipientResult{Address: addr, Status: Suppressed, Reason: entry.Reason})
+			continue
 		}
 
-		if _, err = w.Write(msgByte); err != nil {
-			return "", err
+		if err := sender.Rcpt(addr); err != nil {
+			if _, ok := err.(*textproto.Error); !ok {
+				return result, err
+			}
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+			continue
 		}
+		accepted = append(accepted, addr)
+	}
+	if len(accepted) == 0 {
+		return result, sender.Reset()
+	}
 
-		if err = w.Close(); err != nil {
-			return "", err
+	w, _, _, err := sender.Data()
+	if err == nil {
+		if _, err = io.Copy(w, r); err != nil {
+			sender.abort()
+			return result, err
 		}
+		err = confirm(w.Close())
+	}
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			return result, err
+		}
+		for _, addr := range accepted {
+			result.add(RecipientResult{Address: addr, Status: Failed, Err: err})
+		}
+		return result, sender.Reset()
+	}
 
-		// code, resp, err := sender.Text.ReadResponse(250)
-		// if err != nil {
-		// 	return "", err
-		// }
+	for _, addr := range accepted {
+		result.add(RecipientResult{Address: addr, Status: Sent})
+	}
+	return result, nil
+}
 
-		// return fmt.Sprintf("%d %s", code, resp), sender.Quit()
-		return "", sender.Quit()
+//Start a transaction, reconnecting if the connection was closed
+func (sender *smtpSender) mail(from string) error {
+	err := sender.Mail(from)
+	if err != io.EOF {
+		return err
 	}
 
-	return "", fmt.Errorf("Whoa!!....It should not come. Looks like recipient address are empty!")
+	// This is probably due to a timeout, so reconnect and try again.
+	sender.Client.Close()
+	sc, err := sender.d.Dial()
+	if err != nil {
+		return fmt.Errorf("m-mail: unable to Dial! Error: %s", err.Error())
+	}
+	if s, ok := sc.(*smtpSender); ok {
+		*sender = *s
+	}
+	return sender.Mail(from)
+}
+
+//Return the suppression entry of a recipient in store, if any
+func suppressed(store suppression.Store, addr, category string) (*suppression.Entry, error) {
+	if store == nil {
+		return nil, nil
+	}
+	return store.Lookup(addr, category)
 }
@@ -0,0 +1,216 @@
+package sender_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+func newTestMessage(to ...string) *message.Message {
+	msg := message.NewMessage("Hello", "Hello there", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", to...)
+	return msg
+}
+
+func dial(t *testing.T, dialer *sender.Dialer) sender.SendCloser {
+	t.Helper()
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestSend(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	conn := dial(t, srv.Dialer())
+	defer conn.Close()
+
+	result, err := conn.Send(newTestMessage("bob@example.com", "carol@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 2 {
+		t.Fatalf("unexpected result %v", result)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 2 {
+		t.Fatalf("got %d transactions, want one per recipient", len(transactions))
+	}
+	for index, to := range []string{"bob@example.com", "carol@example.com"} {
+		tx := transactions[index]
+		if tx.From != "alice@example.com" || len(tx.To) != 1 || tx.To[0] != to {
+			t.Errorf("unexpected envelope %s %v", tx.From, tx.To)
+		}
+		if !strings.Contains(string(tx.Data), "To: "+to+"\n") {
+			t.Errorf("message not rendered for %s:\n%s", to, tx.Data)
+		}
+	}
+}
+
+func TestSendAbortsTruncatedMessage(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	copyErr := errors.New("disk failure")
+	msg := newTestMessage("bob@example.com")
+	msg.Attach("report.pdf", message.SetCopyFunc(func(w io.Writer) error {
+		if _, err := w.Write(make([]byte, 64*1024)); err != nil {
+			return err
+		}
+		return copyErr
+	}))
+
+	conn := dial(t, srv.Dialer())
+	defer conn.Close()
+
+	if _, err := conn.Send(msg); err == nil || !strings.Contains(err.Error(), copyErr.Error()) {
+		t.Fatalf("Send returned %v, want the copy error", err)
+	}
+
+	// The server sees the connection close in the middle of the data, which
+	// it must not deliver.
+	time.Sleep(100 * time.Millisecond)
+	if transactions := srv.Transactions(); len(transactions) != 0 {
+		t.Fatalf("the truncated message was delivered:\n%s", transactions[0].Data)
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	conn := dial(t, srv.Dialer())
+	defer conn.Close()
+
+	raw := "From: alice@example.com\r\nSubject: Hello\r\n\r\nHello there\r\n"
+	result, err := conn.(sender.RawSender).SendRaw("alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := result.Addresses(sender.Sent); len(sent) != 2 {
+		t.Fatalf("unexpected result %v", result)
+	}
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions, want a single one", len(transactions))
+	}
+	tx := transactions[0]
+	if len(tx.To) != 2 || string(tx.Data) != strings.ReplaceAll(raw, "\r\n", "\n") {
+		t.Errorf("unexpected transaction %v %q", tx.To, tx.Data)
+	}
+}
+
+// failingReader returns its content then fails.
+type failingReader struct {
+	content io.Reader
+	err     error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestSendRawAbortsTruncatedMessage(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+
+	conn := dial(t, srv.Dialer())
+	defer conn.Close()
+
+	readErr := errors.New("read failure")
+	r := &failingReader{
+		content: strings.NewReader("Subject: Hello\r\n\r\n" + strings.Repeat("x", 64*1024)),
+		err:     readErr,
+	}
+	if _, err := conn.(sender.RawSender).SendRaw("alice@example.com", []string{"bob@example.com"}, r); err != readErr {
+		t.Fatalf("SendRaw returned %v, want %v", err, readErr)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if transactions := srv.Transactions(); len(transactions) != 0 {
+		t.Fatalf("the truncated message was delivered:\n%s", transactions[0].Data)
+	}
+}
+
+// scriptedDialer returns a dialer connected to a server replying to each
+// command with the reply of its verb in replies, and a channel closed once the
+// client closes the connection.
+func scriptedDialer(greeting string, replies map[string]string) (*sender.Dialer, <-chan struct{}) {
+	closed := make(chan struct{})
+	dialer := sender.NewDialer("smtp.example.com", 25, "", "")
+	dialer.StartTLSPolicy = sender.NoStartTLS
+	dialer.DialContextFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer close(closed)
+			text := textproto.NewConn(server)
+			text.PrintfLine("%s", greeting)
+			for {
+				line, err := text.ReadLine()
+				if err != nil {
+					return
+				}
+				text.PrintfLine("%s", replies[strings.Fields(line)[0]])
+			}
+		}()
+		return client, nil
+	}
+	return dialer, closed
+}
+
+//Wait for the client to close the connection of a scripted server
+func waitClosed(t *testing.T, closed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed")
+	}
+}
+
+func TestDialClosesOnError(t *testing.T) {
+	dialer, closed := scriptedDialer("554 No service", nil)
+	if _, err := dialer.Dial(); err == nil {
+		t.Fatal("Dial succeeded without a greeting")
+	}
+	waitClosed(t, closed)
+
+	dialer, closed = scriptedDialer("220 smtp.example.com", map[string]string{"EHLO": "550 Go away", "HELO": "550 Go away"})
+	dialer.LocalName = "client.example.com"
+	if _, err := dialer.Dial(); err == nil {
+		t.Fatal("Dial succeeded with a rejected EHLO")
+	}
+	waitClosed(t, closed)
+}
+
+func TestCloseWithFailingQuit(t *testing.T) {
+	dialer, closed := scriptedDialer("220 smtp.example.com", map[string]string{"EHLO": "250 smtp.example.com", "QUIT": "500 No"})
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err == nil {
+		t.Error("Close ignored the failing QUIT command")
+	}
+	waitClosed(t, closed)
+}
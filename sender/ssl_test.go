@@ -0,0 +1,145 @@
+package sender_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+// transcriptLogger records the debug messages, which hold the transcripts.
+type transcriptLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *transcriptLogger) Debug(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines = append(l.lines, fmt.Sprintln(args...))
+}
+
+func (l *transcriptLogger) Info(msg string, args ...interface{})  {}
+func (l *transcriptLogger) Warn(msg string, args ...interface{})  {}
+func (l *transcriptLogger) Error(msg string, args ...interface{}) {}
+
+func (l *transcriptLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return strings.Join(l.lines, "")
+}
+
+// sslProxy listens with implicit TLS and forwards the connections to srv. It
+// returns its port and the pool trusting its certificate.
+func sslProxy(t *testing.T, srv *smtptest.Server) (int, *x509.CertPool) {
+	t.Helper()
+
+	// The certificate of httptest is valid for 127.0.0.1 and example.com.
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			client, err := l.Accept()
+			if err != nil {
+				return
+			}
+			backend, err := net.Dial("tcp", srv.Addr)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			go func() {
+				io.Copy(backend, client)
+				backend.Close()
+			}()
+			go func() {
+				io.Copy(client, backend)
+				client.Close()
+			}()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, roots
+}
+
+func TestSSLTranscript(t *testing.T) {
+	srv := smtptest.NewServer()
+	defer srv.Close()
+	port, roots := sslProxy(t, srv)
+
+	// The server is given a name that is not local, to which PLAIN is only
+	// used over TLS.
+	logger := &transcriptLogger{}
+	dialer := sender.NewDialer("example.com", port, "alice", "ssl-secret")
+	dialer.SSL, dialer.Timeout = true, 5*time.Second
+	dialer.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "example.com"}
+	dialer.DialContextFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", "127.0.0.1:"+strconv.Itoa(port))
+	}
+	dialer.Logger = logger
+
+	conn := dial(t, dialer)
+	if _, err := conn.Send(newTestMessage("bob@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if tx := srv.Transactions()[0]; tx.Username != "alice" || tx.Password != "ssl-secret" {
+		t.Errorf("authenticated as %q with %q", tx.Username, tx.Password)
+	}
+
+	transcript := logger.String()
+	for _, want := range []string{"server 220 smtptest ESMTP Service Ready", "AUTH PLAIN [redacted]", "bytes]"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("%q is missing from the transcript:\n%s", want, transcript)
+		}
+	}
+	if strings.Contains(transcript, "ssl-secret") || strings.Contains(transcript, "Hello there") {
+		t.Errorf("the transcript is not redacted:\n%s", transcript)
+	}
+}
+
+func TestStartTLSTranscript(t *testing.T) {
+	srv := smtptest.NewTLSServer()
+	defer srv.Close()
+
+	logger := &transcriptLogger{}
+	dialer := srv.Dialer()
+	dialer.StartTLSPolicy = sender.MandatoryStartTLS
+	dialer.Logger = logger
+
+	conn := dial(t, dialer)
+	if _, err := conn.Send(newTestMessage("bob@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The client greets the server again once the connection is encrypted.
+	transcript := logger.String()
+	starttls := strings.Index(transcript, "client STARTTLS")
+	if starttls == -1 || !strings.Contains(transcript[starttls:], "client EHLO localhost") {
+		t.Errorf("the EHLO sent over TLS is missing from the transcript:\n%s", transcript)
+	}
+}
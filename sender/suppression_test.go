@@ -0,0 +1,54 @@
+package sender
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/suppression"
+)
+
+// failingStore is a suppression.Store whose lookups fail.
+type failingStore struct {
+	suppression.Store
+	err error
+}
+
+func (store failingStore) Lookup(address, category string) (*suppression.Entry, error) {
+	return nil, store.err
+}
+
+func TestSendSuppressed(t *testing.T) {
+	store := suppression.NewMemoryStore()
+	store.Add(&suppression.Entry{Address: "bob@example.com", Reason: suppression.HardBounce})
+	store.Add(&suppression.Entry{Address: "carol@example.com", Category: "news", Reason: suppression.Unsubscribe})
+
+	msg := message.NewMessage("Hello", "Hello there", "text/plain", message.SetCategory("news"))
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com", "carol@example.com")
+
+	// No command is sent to the server when every recipient is suppressed.
+	sender := &smtpSender{d: &Dialer{Suppressions: store}}
+	result, err := sender.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RecipientResult{
+		{Address: "bob@example.com", Status: Suppressed, Reason: suppression.HardBounce},
+		{Address: "carol@example.com", Status: Suppressed, Reason: suppression.Unsubscribe},
+	}
+	if len(result.Recipients) != len(want) {
+		t.Fatalf("unexpected result %v", result)
+	}
+	for index, recipient := range result.Recipients {
+		if recipient != want[index] {
+			t.Errorf("got %+v, want %+v", recipient, want[index])
+		}
+	}
+
+	lookupErr := errors.New("store unavailable")
+	sender.d.Suppressions = failingStore{err: lookupErr}
+	if _, err := sender.Send(msg); err != lookupErr {
+		t.Fatalf("Send returned %v, want %v", err, lookupErr)
+	}
+}
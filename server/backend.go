@@ -0,0 +1,49 @@
+package server
+
+import (
+	"io"
+)
+
+// A Backend creates the sessions of the connections accepted by a Server.
+type Backend interface {
+	// NewSession is called when a client connects. When an error is returned,
+	// the client is told that the service is not available and disconnected.
+	NewSession(conn *Conn) (Session, error)
+}
+
+// A Session handles the transactions of a connection. Returning an *Error
+// from its methods sets the reply sent to the client, other errors are replied
+// with a temporary failure.
+type Session interface {
+	// Mail is called with the sender of a new transaction, which is empty for
+	// bounces.
+	Mail(from string, opts *MailOptions) error
+	// Rcpt is called with each recipient of the transaction.
+	Rcpt(to string) error
+	// Data is called with the content of the message, once its recipients are
+	// accepted. The message can be read with message.Parse.
+	Data(r io.Reader) error
+	// Reset discards the current transaction.
+	Reset()
+	// Logout is called when the connection is closed.
+	Logout() error
+}
+
+// An AuthSession is a Session supporting the AUTH command, with the PLAIN and
+// LOGIN mechanisms.
+type AuthSession interface {
+	Session
+	// Auth authenticates the client with the given credentials.
+	Auth(username, password string) error
+}
+
+// MailOptions are the parameters of the MAIL command.
+type MailOptions struct {
+	// Size is the size of the message declared by the client, if any.
+	Size int64
+	// Body is the type of the body declared by the client, "7BIT" or
+	// "8BITMIME", if any.
+	Body string
+	// UTF8 is true when the client requested the SMTPUTF8 extension.
+	UTF8 bool
+}
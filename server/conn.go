@@ -0,0 +1,600 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum lengths of the lines sent by the clients, including their CRLF
+// ending, as defined in RFC 5321 and, for AUTH, in RFC 4954.
+const (
+	maxCommandLine = 512
+	maxTextLine    = 1000
+	maxAuthLine    = 12288
+)
+
+// A Conn is a connection of a client to a Server.
+type Conn struct {
+	server *Server
+	// raw is the accepted connection, which conn wraps after STARTTLS.
+	raw  net.Conn
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+
+	session    Session
+	hostname   string
+	username   string
+	hasMail    bool
+	recipients int
+}
+
+func newConn(srv *Server, netConn net.Conn) *Conn {
+	conn := &Conn{server: srv, raw: netConn}
+	conn.setConn(netConn)
+	return conn
+}
+
+// Hostname returns the name the client gave with HELO or EHLO.
+func (conn *Conn) Hostname() string {
+	return conn.hostname
+}
+
+// RemoteAddr returns the address of the client.
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.raw.RemoteAddr()
+}
+
+// TLS returns the state of the TLS connection, if the connection uses TLS.
+func (conn *Conn) TLS() (tls.ConnectionState, bool) {
+	if tlsConn, ok := conn.conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// Username returns the name the client authenticated with, if any.
+func (conn *Conn) Username() string {
+	return conn.username
+}
+
+// Close closes the connection, without replying to the client.
+func (conn *Conn) Close() error {
+	return conn.raw.Close()
+}
+
+func (conn *Conn) setConn(netConn net.Conn) {
+	conn.conn = netConn
+	conn.br = bufio.NewReader(netConn)
+	conn.bw = bufio.NewWriter(netConn)
+}
+
+//Greet the client and handle its commands until it quits or disconnects
+func (conn *Conn) serve() {
+	defer conn.raw.Close()
+
+	session, err := conn.server.Backend.NewSession(conn)
+	if err != nil {
+		conn.reply(421, "4.3.0", "Service not available")
+		conn.bw.Flush()
+		return
+	}
+	conn.session = session
+	defer session.Logout()
+
+	conn.reply(220, "", conn.server.domain()+" ESMTP Service Ready")
+
+	for {
+		// Replies are flushed once the pipelined commands are handled.
+		if conn.br.Buffered() == 0 {
+			if err := conn.flush(); err != nil {
+				return
+			}
+		}
+
+		line, err := conn.readLine(maxAuthLine)
+		if err == errLineTooLong {
+			conn.replyErr(err, 0, "", "")
+			continue
+		} else if err != nil {
+			return
+		}
+
+		cmd, arg := line, ""
+		if index := strings.IndexByte(line, ' '); index >= 0 {
+			cmd, arg = line[:index], strings.TrimSpace(line[index+1:])
+		}
+		cmd = strings.ToUpper(cmd)
+		if cmd != "AUTH" && len(line)+2 > maxCommandLine {
+			conn.replyErr(errLineTooLong, 0, "", "")
+			continue
+		}
+
+		if !conn.handle(cmd, arg) {
+			conn.flush()
+			return
+		}
+	}
+}
+
+//Handle a command, returning false when the connection must be closed
+func (conn *Conn) handle(cmd, arg string) bool {
+	switch cmd {
+	case "HELO", "EHLO":
+		conn.hello(cmd, arg)
+	case "MAIL":
+		conn.replyErr(conn.mail(arg), 250, "2.1.0", "Sender OK")
+	case "RCPT":
+		conn.replyErr(conn.rcpt(arg), 250, "2.1.5", "Recipient OK")
+	case "DATA":
+		return conn.data()
+	case "RSET":
+		conn.reset()
+		conn.reply(250, "2.0.0", "OK")
+	case "NOOP":
+		conn.reply(250, "2.0.0", "OK")
+	case "VRFY":
+		conn.reply(252, "2.5.0", "Cannot verify the user, but will accept the message")
+	case "STARTTLS":
+		return conn.startTLS()
+	case "AUTH":
+		conn.replyErr(conn.auth(arg), 235, "2.7.0", "Authentication succeeded")
+	case "QUIT":
+		conn.reply(221, "2.0.0", "Bye")
+		return false
+	default:
+		conn.reply(500, "5.5.2", "Command not recognized")
+	}
+	return true
+}
+
+func (conn *Conn) hello(cmd, arg string) {
+	if arg == "" {
+		conn.replyErr(errSyntax, 0, "", "")
+		return
+	}
+	conn.hostname = arg
+	conn.reset()
+
+	if cmd == "HELO" {
+		conn.reply(250, "", conn.server.domain()+" Hello "+arg)
+		return
+	}
+
+	lines := []string{conn.server.domain() + " Hello " + arg, "PIPELINING", "8BITMIME", "SMTPUTF8",
+		"ENHANCEDSTATUSCODES"}
+	if _, isTLS := conn.TLS(); !isTLS && conn.server.TLSConfig != nil {
+		lines = append(lines, "STARTTLS")
+	}
+	if conn.canAuth() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	if conn.server.MaxMessageBytes > 0 {
+		lines = append(lines, "SIZE "+strconv.FormatInt(conn.server.MaxMessageBytes, 10))
+	} else {
+		lines = append(lines, "SIZE")
+	}
+	conn.reply(250, "", lines...)
+}
+
+func (conn *Conn) mail(arg string) error {
+	if conn.hostname == "" {
+		return errNoHello
+	}
+	if conn.hasMail {
+		return errBadSeq
+	}
+
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return errSyntax
+	}
+
+	opts := &MailOptions{}
+	for _, param := range params {
+		key, value := param, ""
+		if index := strings.IndexByte(param, '='); index >= 0 {
+			key, value = param[:index], param[index+1:]
+		}
+		switch strings.ToUpper(key) {
+		case "SIZE":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errSyntax
+			}
+			if max := conn.server.MaxMessageBytes; max > 0 && size > max {
+				return errTooLarge
+			}
+			opts.Size = size
+		case "BODY":
+			value = strings.ToUpper(value)
+			if value != "7BIT" && value != "8BITMIME" {
+				return errSyntax
+			}
+			opts.Body = value
+		case "SMTPUTF8":
+			opts.UTF8 = true
+		case "AUTH":
+			// The identity of the submitter is not used.
+		default:
+			return &Error{555, "5.5.4", "Unsupported parameter " + key}
+		}
+	}
+
+	if err := conn.session.Mail(from, opts); err != nil {
+		return err
+	}
+	conn.hasMail = true
+	return nil
+}
+
+func (conn *Conn) rcpt(arg string) error {
+	if !conn.hasMail {
+		return errBadSeq
+	}
+
+	to, _, ok := parsePath(arg, "TO:")
+	if !ok || to == "" {
+		return errSyntax
+	}
+	if max := conn.server.MaxRecipients; max > 0 && conn.recipients >= max {
+		return &Error{452, "4.5.3", "Too many recipients"}
+	}
+
+	if err := conn.session.Rcpt(to); err != nil {
+		return err
+	}
+	conn.recipients++
+	return nil
+}
+
+//Read the message and hand it to the session
+func (conn *Conn) data() bool {
+	if conn.recipients == 0 {
+		conn.replyErr(errBadSeq, 0, "", "")
+		return true
+	}
+
+	conn.reply(354, "", "Start mail input; end with <CRLF>.<CRLF>")
+	if err := conn.flush(); err != nil {
+		return false
+	}
+
+	dr := &dataReader{conn: conn}
+	r := &limitReader{r: dr, n: conn.server.MaxMessageBytes}
+	err := conn.session.Data(r)
+
+	// The rest of the message is read so the next command can be read.
+	if err := dr.discard(); err != nil {
+		return false
+	}
+	if dr.tooLong {
+		err = errLineTooLong
+	} else if r.exceeded {
+		err = errTooLarge
+	}
+
+	conn.replyErr(err, 250, "2.0.0", "OK: queued")
+	conn.reset()
+	return true
+}
+
+func (conn *Conn) startTLS() bool {
+	if _, isTLS := conn.TLS(); isTLS || conn.server.TLSConfig == nil {
+		conn.reply(502, "5.5.1", "STARTTLS not available")
+		return true
+	}
+
+	conn.reply(220, "2.0.0", "Ready to start TLS")
+	if err := conn.flush(); err != nil {
+		return false
+	}
+	// Commands sent before the TLS handshake must not be handled after it.
+	if conn.br.Buffered() > 0 {
+		return false
+	}
+
+	tlsConn := tls.Server(conn.conn, conn.server.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return false
+	}
+
+	// The client must start over once TLS is established, as defined in RFC
+	// 3207.
+	conn.setConn(tlsConn)
+	conn.hostname = ""
+	conn.reset()
+	return true
+}
+
+func (conn *Conn) auth(arg string) error {
+	if conn.hostname == "" {
+		return errNoHello
+	}
+	if conn.username != "" || conn.hasMail {
+		return errBadSeq
+	}
+	session, ok := conn.session.(AuthSession)
+	if !ok || !conn.canAuth() {
+		return &Error{502, "5.5.1", "AUTH not available"}
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return errSyntax
+	}
+	// An empty initial response is sent as "=", as defined in RFC 4954.
+	var initial string
+	if len(fields) > 1 && fields[1] != "=" {
+		initial = fields[1]
+	}
+
+	var username, password string
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		response := initial
+		if response == "" {
+			var err error
+			if response, err = conn.challenge(""); err != nil {
+				return err
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(response)
+		if err != nil {
+			return errSyntax
+		}
+		parts := strings.Split(string(decoded), "\x00")
+		if len(parts) != 3 {
+			return errSyntax
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		for _, prompt := range []string{"Username:", "Password:"} {
+			var response string
+			if prompt == "Username:" && len(fields) > 1 {
+				// The username is sent as an initial response.
+				response = initial
+			} else {
+				var err error
+				if response, err = conn.challenge(prompt); err != nil {
+					return err
+				}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				return errSyntax
+			}
+			if prompt == "Username:" {
+				username = string(decoded)
+			} else {
+				password = string(decoded)
+			}
+		}
+	default:
+		return &Error{504, "5.5.4", "Unrecognized authentication type"}
+	}
+
+	if err := session.Auth(username, password); err != nil {
+		if _, ok := err.(*Error); ok {
+			return err
+		}
+		return &Error{535, "5.7.8", "Authentication credentials invalid"}
+	}
+	conn.username = username
+	return nil
+}
+
+//Send an AUTH challenge and return the response of the client
+func (conn *Conn) challenge(prompt string) (string, error) {
+	conn.reply(334, "", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	if err := conn.flush(); err != nil {
+		return "", err
+	}
+
+	line, err := conn.readLine(maxAuthLine)
+	if err != nil {
+		return "", err
+	}
+	if line == "*" {
+		return "", &Error{501, "5.0.0", "Authentication cancelled"}
+	}
+	return line, nil
+}
+
+//Check whether the client may authenticate
+func (conn *Conn) canAuth() bool {
+	if _, ok := conn.session.(AuthSession); !ok {
+		return false
+	}
+	_, isTLS := conn.TLS()
+	return isTLS || conn.server.AllowInsecureAuth
+}
+
+func (conn *Conn) reset() {
+	if conn.hasMail {
+		conn.session.Reset()
+	}
+	conn.hasMail = false
+	conn.recipients = 0
+}
+
+//Reply err, or the given success reply when err is nil
+func (conn *Conn) replyErr(err error, code int, enhancedCode, text string) {
+	switch e := err.(type) {
+	case nil:
+		conn.reply(code, enhancedCode, text)
+	case *Error:
+		conn.reply(e.Code, e.EnhancedCode, e.Message)
+	default:
+		// The errors of the backend may hold internal details, which are not
+		// sent to the client.
+		conn.reply(451, "4.0.0", "Requested action aborted: local error in processing")
+	}
+}
+
+// replyLineBreaks replaces the line breaks of reply texts, which would
+// otherwise be read by the client as replies of their own.
+var replyLineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+//Write a reply, one line per text
+func (conn *Conn) reply(code int, enhancedCode string, text ...string) {
+	if conn.server.WriteTimeout > 0 {
+		conn.conn.SetWriteDeadline(time.Now().Add(conn.server.WriteTimeout))
+	}
+
+	for index, line := range text {
+		separator := " "
+		if index < len(text)-1 {
+			separator = "-"
+		}
+		line = replyLineBreaks.Replace(line)
+		if enhancedCode != "" {
+			line = enhancedCode + " " + line
+		}
+		fmt.Fprintf(conn.bw, "%d%s%s\r\n", code, separator, line)
+	}
+}
+
+func (conn *Conn) flush() error {
+	return conn.bw.Flush()
+}
+
+//Read a line of at most max bytes including its ending, and return it without
+//its ending. A longer line is read entirely and errLineTooLong is returned. The
+//read deadline is extended before each line, so that the ReadTimeout of the
+//server does not limit the time taken by a large message.
+func (conn *Conn) readLine(max int) (string, error) {
+	if conn.server.ReadTimeout > 0 {
+		conn.conn.SetReadDeadline(time.Now().Add(conn.server.ReadTimeout))
+	}
+
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := conn.br.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > max {
+				tooLong, line = true, nil
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && (len(line) > 0 || tooLong) {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+	if tooLong {
+		return "", errLineTooLong
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if len(line)+2 > max {
+		return "", errLineTooLong
+	}
+	return string(line), nil
+}
+
+//Parse the path of a MAIL or RCPT command, such as "FROM:<address> PARAM=1"
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
+
+// limitReader reads at most n bytes of r, or all of them when n is 0, and
+// reports errTooLarge for larger messages.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	read     int64
+	exceeded bool
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.exceeded {
+		return 0, errTooLarge
+	}
+
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	if lr.n > 0 && lr.read > lr.n {
+		lr.exceeded = true
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// dataReader reads the content of a message until the line holding a single
+// dot. Its lines are returned with LF endings and without the dot added by the
+// client to the lines starting with a dot.
+type dataReader struct {
+	conn *Conn
+	buf  []byte
+	err  error
+	// tooLong is set once a line exceeded maxTextLine.
+	tooLong bool
+}
+
+func (r *dataReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		line, err := r.conn.readLine(maxTextLine)
+		switch {
+		case err == errLineTooLong:
+			r.tooLong = true
+			return 0, err
+		case err == io.EOF:
+			r.err = io.ErrUnexpectedEOF
+		case err != nil:
+			r.err = err
+		case line == ".":
+			r.err = io.EOF
+		default:
+			r.buf = append(append(r.buf, strings.TrimPrefix(line, ".")...), '\n')
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+//Read the rest of the message, whatever the length of its lines
+func (r *dataReader) discard() error {
+	for {
+		_, err := r.Read(make([]byte, 4096))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != errLineTooLong {
+			return err
+		}
+	}
+}
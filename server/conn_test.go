@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBackend records the messages and the credentials of its sessions.
+type testBackend struct {
+	mu       sync.Mutex
+	messages []string
+	logins   []string
+}
+
+func (be *testBackend) NewSession(conn *Conn) (Session, error) {
+	return &testSession{be: be}, nil
+}
+
+func (be *testBackend) Messages() []string {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	return append([]string(nil), be.messages...)
+}
+
+type testSession struct {
+	be *testBackend
+}
+
+func (s *testSession) Auth(username, password string) error {
+	s.be.mu.Lock()
+	s.be.logins = append(s.be.logins, username+":"+password)
+	s.be.mu.Unlock()
+	return nil
+}
+
+func (s *testSession) Mail(from string, opts *MailOptions) error { return nil }
+func (s *testSession) Reset()                                    {}
+func (s *testSession) Logout() error                             { return nil }
+
+// Rcpt fails for the addresses starting with "fail" and rejects the ones
+// starting with "multiline" with a reply spanning several lines.
+func (s *testSession) Rcpt(to string) error {
+	switch {
+	case strings.HasPrefix(to, "fail"):
+		return errors.New("database password rejected")
+	case strings.HasPrefix(to, "multiline"):
+		return &Error{550, "5.1.1", "No such user\r\n250 OK"}
+	}
+	return nil
+}
+
+func (s *testSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.be.mu.Lock()
+	s.be.messages = append(s.be.messages, string(data))
+	s.be.mu.Unlock()
+	return nil
+}
+
+// startServer serves srv on the loopback interface and returns a client
+// connection, greeted by the server.
+func startServer(t *testing.T, srv *Server) *textproto.Conn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := textproto.NewConn(conn)
+	t.Cleanup(func() { client.Close() })
+
+	expect(t, client, 220)
+	cmd(t, client, 250, "EHLO client.example.com")
+	return client
+}
+
+// Read a reply and check its code
+func expect(t *testing.T, client *textproto.Conn, code int) string {
+	t.Helper()
+
+	_, msg, err := client.ReadResponse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Send a command and check the code of its reply
+func cmd(t *testing.T, client *textproto.Conn, code int, format string, args ...interface{}) string {
+	t.Helper()
+
+	if err := client.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	return expect(t, client, code)
+}
+
+func TestData(t *testing.T) {
+	be := &testBackend{}
+	client := startServer(t, NewServer(be))
+
+	cmd(t, client, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, client, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, client, 354, "DATA")
+	cmd(t, client, 250, "Subject: Hello\r\n\r\n..A line starting with a dot\r\n.")
+
+	want := "Subject: Hello\n\n.A line starting with a dot\n"
+	if messages := be.Messages(); len(messages) != 1 || messages[0] != want {
+		t.Fatalf("got %q, want %q", messages, want)
+	}
+}
+
+func TestLineLimits(t *testing.T) {
+	be := &testBackend{}
+	client := startServer(t, NewServer(be))
+
+	cmd(t, client, 500, "MAIL FROM:<%s@example.com>", strings.Repeat("a", maxCommandLine))
+	cmd(t, client, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, client, 250, "RCPT TO:<bob@example.com>")
+
+	cmd(t, client, 354, "DATA")
+	cmd(t, client, 500, "Subject: Hello\r\n\r\n%s\r\n.", strings.Repeat("x", maxTextLine))
+	if messages := be.Messages(); len(messages) != 0 {
+		t.Fatal("a message with a line too long was accepted")
+	}
+
+	cmd(t, client, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, client, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, client, 354, "DATA")
+	cmd(t, client, 250, "Subject: Hello\r\n\r\n%s\r\n.", strings.Repeat("x", maxTextLine-2))
+	if messages := be.Messages(); len(messages) != 1 {
+		t.Fatal("a message with lines of the maximum length was rejected")
+	}
+}
+
+func TestDataReadTimeout(t *testing.T) {
+	be := &testBackend{}
+	srv := NewServer(be)
+	srv.ReadTimeout = 200 * time.Millisecond
+	client := startServer(t, srv)
+
+	cmd(t, client, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, client, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, client, 354, "DATA")
+
+	// The message takes longer than ReadTimeout, but each of its lines is
+	// sent in time.
+	client.PrintfLine("Subject: Hello\r\n")
+	for i := 0; i < 5; i++ {
+		time.Sleep(srv.ReadTimeout / 2)
+		client.PrintfLine("line %d", i)
+	}
+	cmd(t, client, 250, ".")
+}
+
+func TestAuthLoginInitialResponse(t *testing.T) {
+	be := &testBackend{}
+	srv := NewServer(be)
+	srv.AllowInsecureAuth = true
+	client := startServer(t, srv)
+
+	// "alice" and "secret" in base64.
+	if prompt := cmd(t, client, 334, "AUTH LOGIN YWxpY2U="); prompt != "UGFzc3dvcmQ6" {
+		t.Fatalf("got prompt %q, want the password prompt", prompt)
+	}
+	cmd(t, client, 235, "c2VjcmV0")
+
+	if len(be.logins) != 1 || be.logins[0] != "alice:secret" {
+		t.Fatalf("unexpected logins %v", be.logins)
+	}
+}
+
+func TestAuthPlainEmptyInitialResponse(t *testing.T) {
+	be := &testBackend{}
+	srv := NewServer(be)
+	srv.AllowInsecureAuth = true
+	client := startServer(t, srv)
+
+	cmd(t, client, 334, "AUTH PLAIN =")
+	// "\x00alice\x00secret" in base64.
+	cmd(t, client, 235, "AGFsaWNlAHNlY3JldA==")
+
+	if len(be.logins) != 1 || be.logins[0] != "alice:secret" {
+		t.Fatalf("unexpected logins %v", be.logins)
+	}
+}
+
+func TestReplyErr(t *testing.T) {
+	client := startServer(t, NewServer(&testBackend{}))
+
+	cmd(t, client, 250, "MAIL FROM:<alice@example.com>")
+	if msg := cmd(t, client, 451, "RCPT TO:<fail@example.com>"); strings.Contains(msg, "password") {
+		t.Errorf("the backend error was sent to the client: %q", msg)
+	}
+	if msg := cmd(t, client, 550, "RCPT TO:<multiline@example.com>"); msg != "5.1.1 No such user 250 OK" {
+		t.Errorf("unexpected reply %q", msg)
+	}
+	cmd(t, client, 250, "RCPT TO:<bob@example.com>")
+}
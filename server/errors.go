@@ -0,0 +1,29 @@
+package server
+
+import (
+	"strconv"
+)
+
+// An Error is an SMTP reply sent to the client when a Session fails.
+type Error struct {
+	// Code is the reply code, such as 550.
+	Code int
+	// EnhancedCode is the enhanced status code of RFC 3463, such as "5.1.1".
+	EnhancedCode string
+	// Message is the text of the reply.
+	Message string
+}
+
+func (err *Error) Error() string {
+	return "m-mail: smtp error " + strconv.Itoa(err.Code) + " " + err.EnhancedCode + " " + err.Message
+}
+
+// Errors replied by the server.
+var (
+	errTooLarge = &Error{552, "5.3.4", "Message too big"}
+	errBadSeq   = &Error{503, "5.5.1", "Bad sequence of commands"}
+	errSyntax   = &Error{501, "5.5.4", "Syntax error in parameters or arguments"}
+	errNoHello  = &Error{503, "5.5.1", "Send HELO or EHLO first"}
+	// errLineTooLong is replied to lines longer than the limits of RFC 5321.
+	errLineTooLong = &Error{500, "5.5.2", "Line too long"}
+)
@@ -0,0 +1,200 @@
+/*
+	Package server implements an SMTP server, as defined in RFC 5321, with the
+	STARTTLS, AUTH, SIZE, 8BITMIME, PIPELINING and SMTPUTF8 extensions. The
+	transactions of its clients are handed to a Backend.
+*/
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("m-mail: server closed")
+
+// A Server is an SMTP server.
+type Server struct {
+	// Addr is the address to listen on, ":25" by default.
+	Addr string
+	// Backend creates the sessions of the clients.
+	Backend Backend
+	// Domain is the name of the server sent in its greeting. By default, the
+	// hostname of the machine is sent.
+	Domain string
+	// TLSConfig is the TLS configuration used by STARTTLS, which is only
+	// offered when it is set, and by ListenAndServeTLS.
+	TLSConfig *tls.Config
+	// AllowInsecureAuth allows authentication over connections without TLS.
+	AllowInsecureAuth bool
+	// MaxMessageBytes is the maximum size of a message, advertised with the
+	// SIZE extension. There is no limit when it is 0.
+	MaxMessageBytes int64
+	// MaxRecipients is the maximum number of recipients of a message. There is
+	// no limit when it is 0.
+	MaxRecipients int
+	// ReadTimeout is the maximum duration of reading a command, or a line of
+	// a message, so that large messages are not cut off. WriteTimeout is the
+	// maximum duration of writing a reply. There is no timeout when they are
+	// 0.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a new Server handing its sessions to backend.
+func NewServer(backend Backend) *Server {
+	return &Server{Backend: backend}
+}
+
+// ListenAndServe listens on Addr and serves the clients.
+func (srv *Server) ListenAndServe() error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":25"
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// ListenAndServeTLS listens on Addr and serves the clients over implicit TLS,
+// usually on port 465. It requires TLSConfig.
+func (srv *Server) ListenAndServeTLS() error {
+	if srv.TLSConfig == nil {
+		return errors.New("m-mail: TLSConfig is required")
+	}
+
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":465"
+	}
+
+	l, err := tls.Listen("tcp", addr, srv.TLSConfig)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts the connections of l and serves each of them in its own
+// goroutine. It returns when l fails or the server is closed.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.listeners, l)
+		srv.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		conn := newConn(srv, netConn)
+		if !srv.track(conn) {
+			netConn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			conn.serve()
+			srv.untrack(conn)
+		}()
+	}
+}
+
+// ServeConn serves a single connection, such as the standard input and output
+// of a process, and returns when it is closed.
+func (srv *Server) ServeConn(netConn net.Conn) {
+	conn := newConn(srv, netConn)
+	if !srv.track(conn) {
+		netConn.Close()
+		return
+	}
+	conn.serve()
+	srv.untrack(conn)
+}
+
+// Close closes the listeners and the connections of the server.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.closed = true
+	var firstErr error
+	for l := range srv.listeners {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for conn := range srv.conns {
+		conn.raw.Close()
+	}
+	return firstErr
+}
+
+func (srv *Server) track(conn *Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closed {
+		return false
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[*Conn]struct{})
+	}
+	srv.conns[conn] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrack(conn *Conn) {
+	srv.mu.Lock()
+	delete(srv.conns, conn)
+	srv.mu.Unlock()
+}
+
+//Return the name of the server sent in its replies
+func (srv *Server) domain() string {
+	if srv.Domain != "" {
+		return srv.Domain
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "localhost"
+}
@@ -0,0 +1,311 @@
+/*
+	Package smtptest provides an SMTP server for tests, recording the
+	transactions of its clients, like net/http/httptest.
+*/
+package smtptest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/server"
+)
+
+// A Server is an SMTP server listening on a random port of the loopback
+// interface. Its hooks must be set before it is started.
+type Server struct {
+	// Addr is the address of the server, such as "127.0.0.1:52413".
+	Addr string
+	// Host and Port are the host and the port of Addr.
+	Host string
+	Port int
+
+	// AuthFunc checks the credentials of the clients. By default, any
+	// credentials are accepted.
+	AuthFunc func(username, password string) error
+	// OnMail, OnRcpt and OnData can fail the MAIL, RCPT and DATA commands by
+	// returning an error, which is replied as defined in the server package:
+	// &server.Error{Code: 421, ...} replies a 421 for instance.
+	OnMail func(from string) error
+	OnRcpt func(to string) error
+	OnData func(data []byte) error
+	// DropAfterData closes the connection once the data of a message is read,
+	// without replying to the client.
+	DropAfterData bool
+	// Delay is waited before each reply of the server.
+	Delay time.Duration
+
+	srv      *server.Server
+	listener net.Listener
+	cert     *x509.Certificate
+
+	mu           sync.Mutex
+	transactions []*Transaction
+}
+
+// A Transaction is a mail transaction received by a Server.
+type Transaction struct {
+	// Hostname is the name the client gave with HELO or EHLO.
+	Hostname string
+	// Username and Password are the credentials of the client, if it
+	// authenticated.
+	Username string
+	Password string
+	// TLS is true when the transaction was sent over TLS.
+	TLS bool
+	// From and To are the envelope of the message.
+	From        string
+	To          []string
+	MailOptions server.MailOptions
+	// Data is the message as received, with LF line endings.
+	Data []byte
+}
+
+// Message parses the data of the transaction.
+func (tx *Transaction) Message() (*message.Message, error) {
+	return message.Parse(bytes.NewReader(tx.Data))
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when
+// finished, to shut it down.
+func NewServer() *Server {
+	srv := NewUnstartedServer()
+	srv.Start()
+	return srv
+}
+
+// NewTLSServer starts and returns a new Server offering STARTTLS, with a
+// self-signed certificate trusted by the Dialer of the server.
+func NewTLSServer() *Server {
+	srv := NewUnstartedServer()
+	srv.StartTLS()
+	return srv
+}
+
+// NewUnstartedServer returns a new Server listening but not started, so that
+// its hooks can be set. Start or StartTLS must be called to start it.
+func NewUnstartedServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("smtptest: failed to listen on a port: " + err.Error())
+	}
+
+	addr := l.Addr().(*net.TCPAddr)
+	srv := &Server{
+		Addr:     addr.String(),
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		listener: l,
+	}
+	srv.srv = server.NewServer(&backend{srv: srv})
+	srv.srv.Domain = "smtptest"
+	srv.srv.AllowInsecureAuth = true
+	return srv
+}
+
+// Start starts the server.
+func (srv *Server) Start() {
+	go srv.srv.Serve(&delayListener{Listener: srv.listener, delay: srv.Delay})
+}
+
+// StartTLS starts the server and offers STARTTLS to its clients.
+func (srv *Server) StartTLS() {
+	cert, err := newCertificate()
+	if err != nil {
+		panic("smtptest: failed to create a certificate: " + err.Error())
+	}
+	srv.cert = cert.Leaf
+	srv.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.Start()
+}
+
+// Close shuts down the server.
+func (srv *Server) Close() {
+	srv.srv.Close()
+}
+
+// Certificate returns the certificate of a server started with StartTLS.
+func (srv *Server) Certificate() *x509.Certificate {
+	return srv.cert
+}
+
+// Dialer returns a Dialer to the server, which trusts its certificate.
+func (srv *Server) Dialer() *sender.Dialer {
+	dialer := sender.NewDialer(srv.Host, srv.Port, "", "")
+	if srv.cert != nil {
+		roots := x509.NewCertPool()
+		roots.AddCert(srv.cert)
+		dialer.TLSConfig = &tls.Config{RootCAs: roots, ServerName: srv.Host}
+	}
+	return dialer
+}
+
+// Transactions returns the transactions received by the server so far.
+func (srv *Server) Transactions() []*Transaction {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return append([]*Transaction(nil), srv.transactions...)
+}
+
+// Reset forgets the transactions received by the server.
+func (srv *Server) Reset() {
+	srv.mu.Lock()
+	srv.transactions = nil
+	srv.mu.Unlock()
+}
+
+func (srv *Server) record(tx *Transaction) {
+	srv.mu.Lock()
+	srv.transactions = append(srv.transactions, tx)
+	srv.mu.Unlock()
+}
+
+// backend records the transactions of a Server.
+type backend struct {
+	srv *Server
+}
+
+func (be *backend) NewSession(conn *server.Conn) (server.Session, error) {
+	return &session{srv: be.srv, conn: conn}, nil
+}
+
+// session is the session of a connection to a Server.
+type session struct {
+	srv      *Server
+	conn     *server.Conn
+	password string
+	tx       *Transaction
+}
+
+func (s *session) Auth(username, password string) error {
+	if s.srv.AuthFunc != nil {
+		if err := s.srv.AuthFunc(username, password); err != nil {
+			return err
+		}
+	}
+	s.password = password
+	return nil
+}
+
+func (s *session) Mail(from string, opts *server.MailOptions) error {
+	if s.srv.OnMail != nil {
+		if err := s.srv.OnMail(from); err != nil {
+			return err
+		}
+	}
+
+	_, isTLS := s.conn.TLS()
+	s.tx = &Transaction{
+		Hostname:    s.conn.Hostname(),
+		TLS:         isTLS,
+		From:        from,
+		MailOptions: *opts,
+	}
+	if username := s.conn.Username(); username != "" {
+		s.tx.Username, s.tx.Password = username, s.password
+	}
+	return nil
+}
+
+func (s *session) Rcpt(to string) error {
+	if s.srv.OnRcpt != nil {
+		if err := s.srv.OnRcpt(to); err != nil {
+			return err
+		}
+	}
+	s.tx.To = append(s.tx.To, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.srv.DropAfterData {
+		return s.conn.Close()
+	}
+	if s.srv.OnData != nil {
+		if err := s.srv.OnData(data); err != nil {
+			return err
+		}
+	}
+
+	s.tx.Data = data
+	s.srv.record(s.tx)
+	return nil
+}
+
+func (s *session) Reset() {
+	s.tx = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+// delayListener delays the writes of its connections.
+type delayListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l *delayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || l.delay == 0 {
+		return conn, err
+	}
+	return &delayConn{Conn: conn, delay: l.delay}, nil
+}
+
+type delayConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (conn *delayConn) Write(p []byte) (int, error) {
+	time.Sleep(conn.delay)
+	return conn.Conn.Write(p)
+}
+
+//Return a self-signed certificate for the loopback interface
+func newCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{Organization: []string{"smtptest"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,99 @@
+package smtptest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/server"
+	"github.com/ishail/m-mail/smtptest"
+)
+
+func TestServer(t *testing.T) {
+	srv := smtptest.NewTLSServer()
+	defer srv.Close()
+
+	dialer := srv.Dialer()
+	dialer.Username, dialer.Password = "alice", "secret"
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.NewMessage("Hello", "Hello there", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com")
+	if _, err := conn.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	transactions := srv.Transactions()
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+	tx := transactions[0]
+	if !tx.TLS || tx.Username != "alice" || tx.Password != "secret" ||
+		tx.From != "alice@example.com" || len(tx.To) != 1 || tx.To[0] != "bob@example.com" {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+
+	received, err := tx.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := received.GetBody(); received.GetSubject() != "Hello" || strings.TrimSpace(body) != "Hello there" {
+		t.Errorf("unexpected message %q %q", received.GetSubject(), body)
+	}
+
+	srv.Reset()
+	if len(srv.Transactions()) != 0 {
+		t.Error("Reset kept the transactions")
+	}
+}
+
+func TestServerHooks(t *testing.T) {
+	srv := smtptest.NewUnstartedServer()
+	srv.AuthFunc = func(username, password string) error {
+		if password != "secret" {
+			return errors.New("invalid credentials")
+		}
+		return nil
+	}
+	srv.OnRcpt = func(to string) error {
+		if to == "carol@example.com" {
+			return &server.Error{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"}
+		}
+		return nil
+	}
+	srv.Start()
+	defer srv.Close()
+
+	dialer := srv.Dialer()
+	dialer.Username, dialer.Password = "alice", "wrong"
+	if _, err := dialer.Dial(); err == nil {
+		t.Fatal("invalid credentials were accepted")
+	}
+
+	dialer.Password = "secret"
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := message.NewMessage("Hello", "Hello there", "text/plain")
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com", "carol@example.com")
+	result, err := conn.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := result.Addresses(sender.Failed); len(failed) != 1 || failed[0] != "carol@example.com" {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if transactions := srv.Transactions(); len(transactions) != 1 || transactions[0].To[0] != "bob@example.com" {
+		t.Fatalf("unexpected transactions %v", transactions)
+	}
+}
@@ -0,0 +1,75 @@
+package suppression
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileStore is a Store backed by a file holding one JSON encoded entry per
+// line. The entries are loaded in memory when the store is opened and every
+// added entry is appended to the file. It is safe for concurrent use.
+type FileStore struct {
+	memory *MemoryStore
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileStore opens the file store at path, creating the file if it does not
+// exist. The returned store should be closed when done using it.
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStore{memory: NewMemoryStore(), file: file}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("m-mail: invalid suppression entry at %s:%d: %v", path, line, err)
+		}
+		store.memory.Add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Add records a suppressed address and appends it to the file.
+func (store *FileStore) Add(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, err := store.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return store.memory.Add(entry)
+}
+
+// Lookup returns the entry suppressing an address for a category, or nil.
+func (store *FileStore) Lookup(address, category string) (*Entry, error) {
+	return store.memory.Lookup(address, category)
+}
+
+// Close closes the file of the store.
+func (store *FileStore) Close() error {
+	return store.file.Close()
+}
@@ -0,0 +1,65 @@
+package suppression
+
+import (
+	"sync"
+)
+
+// MemoryStore is a Store keeping its entries in memory. It is safe for
+// concurrent use.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*Entry)}
+}
+
+// Add records a suppressed address, replacing the entry of the same address and
+// category if any.
+func (store *MemoryStore) Add(entry *Entry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.entries[entryKey(entry.Address, entry.Category)] = entry
+	return nil
+}
+
+// Lookup returns the entry suppressing an address for a category, or nil.
+func (store *MemoryStore) Lookup(address, category string) (*Entry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if entry, ok := store.entries[entryKey(address, "")]; ok {
+		return entry, nil
+	}
+	if category != "" {
+		if entry, ok := store.entries[entryKey(address, category)]; ok {
+			return entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Remove deletes the entry of an address and a category.
+func (store *MemoryStore) Remove(address, category string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.entries, entryKey(address, category))
+	return nil
+}
+
+// Entries returns every entry of the store.
+func (store *MemoryStore) Entries() []*Entry {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	entries := make([]*Entry, 0, len(store.entries))
+	for _, entry := range store.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
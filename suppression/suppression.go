@@ -0,0 +1,51 @@
+/*
+	Package suppression keeps track of the addresses that must not receive emails
+	anymore.
+*/
+package suppression
+
+import (
+	"strings"
+	"time"
+)
+
+// Reason is the reason why an address is suppressed.
+type Reason string
+
+const (
+	// HardBounce is the reason of the addresses rejected permanently by their
+	// server.
+	HardBounce Reason = "hard-bounce"
+	// Complaint is the reason of the addresses whose owner reported an email as
+	// spam.
+	Complaint Reason = "complaint"
+	// Unsubscribe is the reason of the addresses whose owner opted out.
+	Unsubscribe Reason = "unsubscribe"
+)
+
+// An Entry is a suppressed address. An entry with an empty Category applies to
+// every email, otherwise it only applies to the emails of that category, such
+// as a mailing list.
+type Entry struct {
+	Address  string    `json:"address"`
+	Category string    `json:"category,omitempty"`
+	Reason   Reason    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+// Store is the interface that groups the Add and Lookup methods.
+//
+// Add records a suppressed address.
+//
+// Lookup returns the entry suppressing an address for the emails of a category,
+// or nil if the address is not suppressed. Entries without category apply to
+// every category.
+type Store interface {
+	Add(entry *Entry) error
+	Lookup(address, category string) (*Entry, error)
+}
+
+//Return the key of an address and a category in a store
+func entryKey(address, category string) string {
+	return strings.ToLower(strings.TrimSpace(address)) + "\x00" + category
+}
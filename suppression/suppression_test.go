@@ -0,0 +1,88 @@
+package suppression
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	store.Add(&Entry{Address: "Bob@Example.com ", Reason: HardBounce})
+	store.Add(&Entry{Address: "carol@example.com", Category: "news", Reason: Unsubscribe})
+
+	tests := []struct {
+		address, category string
+		reason            Reason
+	}{
+		{"bob@example.com", "", HardBounce},
+		{"BOB@example.com", "news", HardBounce},
+		{"carol@example.com", "news", Unsubscribe},
+		{"carol@example.com", "", ""},
+		{"carol@example.com", "offers", ""},
+		{"dave@example.com", "", ""},
+	}
+	for _, test := range tests {
+		entry, err := store.Lookup(test.address, test.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reason Reason
+		if entry != nil {
+			reason = entry.Reason
+		}
+		if reason != test.reason {
+			t.Errorf("Lookup(%q, %q) = %q, want %q", test.address, test.category, reason, test.reason)
+		}
+	}
+
+	store.Remove("carol@example.com", "news")
+	if entry, _ := store.Lookup("carol@example.com", "news"); entry != nil {
+		t.Error("the entry was not removed")
+	}
+	if entries := store.Entries(); len(entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(entries))
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressions.jsonl")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := store.Add(&Entry{Address: "bob@example.com", Category: "news", Reason: Complaint, Time: added}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	entry, err := store.Lookup("bob@example.com", "news")
+	if err != nil || entry == nil {
+		t.Fatalf("Lookup() = %v, %v", entry, err)
+	}
+	if entry.Reason != Complaint || !entry.Time.Equal(added) {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+func TestFileStoreInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressions.jsonl")
+	if err := os.WriteFile(path, []byte("{\"address\":\"bob@example.com\"}\n\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileStore(path); err == nil {
+		t.Fatal("an invalid file was opened")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+	Package template renders email templates into Message objects.
+
+	A template named "welcome" is made of up to three files found in the file
+	system given to New:
+
+		welcome.subject.tmpl	the subject, rendered with text/template
+		welcome.text.tmpl	the plain text body, rendered with text/template
+		welcome.html.tmpl	the HTML body, rendered with html/template
+
+	At least one of the two bodies must exist. Layouts and partials shared by every
+	template are added with SetLayouts.
+*/
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/ishail/m-mail/message"
+)
+
+const (
+	subjectSuffix = ".subject.tmpl"
+	textSuffix    = ".text.tmpl"
+	htmlSuffix    = ".html.tmpl"
+)
+
+// FuncMap is the type of the map defining the functions available to the
+// templates. It is shared by the text and HTML templates.
+type FuncMap map[string]interface{}
+
+// A Set loads, caches and renders the templates found in a file system. It is
+// safe for concurrent use.
+type Set struct {
+	fsys    fs.FS
+	layouts []string
+	funcs   FuncMap
+
+	mu    sync.Mutex
+	cache map[string]*entry
+}
+
+// A Setting can be used as an argument in New to configure a Set.
+type Setting func(set *Set)
+
+// entry holds the parsed templates of a name. A nil template means the file
+// does not exist.
+type entry struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// New returns a Set reading its templates from fsys.
+func New(fsys fs.FS, settings ...Setting) *Set {
+	set := &Set{
+		fsys:  fsys,
+		cache: make(map[string]*entry),
+	}
+
+	for _, setting := range settings {
+		setting(set)
+	}
+
+	return set
+}
+
+// SetLayouts is a setting to add the files matching the given patterns to every
+// template of the Set. Files ending with ".html.tmpl" are added to the HTML
+// bodies, the others to the subjects and plain text bodies.
+func SetLayouts(patterns ...string) Setting {
+	return func(set *Set) {
+		set.layouts = append(set.layouts, patterns...)
+	}
+}
+
+// SetFuncs is a setting to add functions to the templates of the Set.
+func SetFuncs(funcs FuncMap) Setting {
+	return func(set *Set) {
+		if set.funcs == nil {
+			set.funcs = make(FuncMap)
+		}
+		for name, fn := range funcs {
+			set.funcs[name] = fn
+		}
+	}
+}
+
+// Render executes the template with the given name and data and returns the
+// resulting Message. When the template has both a plain text and an HTML body,
+// the HTML body is added as an alternative of the plain text one.
+func (set *Set) Render(name string, data interface{}, settings ...message.MessageSetting) (*message.Message, error) {
+	tmpl, err := set.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := ""
+	if tmpl.subject != nil {
+		var buff bytes.Buffer
+		if err := tmpl.subject.Execute(&buff, data); err != nil {
+			return nil, fmt.Errorf("m-mail: unable to render subject of %q: %v", name, err)
+		}
+		subject = strings.Join(strings.Fields(buff.String()), " ")
+	}
+
+	var text, html bytes.Buffer
+	if tmpl.text != nil {
+		if err := tmpl.text.ExecuteTemplate(&text, path.Base(name+textSuffix), data); err != nil {
+			return nil, fmt.Errorf("m-mail: unable to render text body of %q: %v", name, err)
+		}
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.ExecuteTemplate(&html, path.Base(name+htmlSuffix), data); err != nil {
+			return nil, fmt.Errorf("m-mail: unable to render HTML body of %q: %v", name, err)
+		}
+	}
+
+	if tmpl.text == nil {
+		return message.NewMessage(subject, html.String(), "text/html", settings...), nil
+	}
+
+	msg := message.NewMessage(subject, text.String(), "text/plain", settings...)
+	if tmpl.html != nil {
+		msg.AddAlternative("text/html", html.String())
+	}
+
+	return msg, nil
+}
+
+//Return the parsed templates of name, parsing them on first use
+func (set *Set) lookup(name string) (*entry, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if tmpl, ok := set.cache[name]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := set.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	set.cache[name] = tmpl
+
+	return tmpl, nil
+}
+
+func (set *Set) parse(name string) (*entry, error) {
+	var textLayouts, htmlLayouts []string
+	for _, pattern := range set.layouts {
+		files, err := fs.Glob(set.fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("m-mail: invalid layout pattern %q: %v", pattern, err)
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, htmlSuffix) {
+				htmlLayouts = append(htmlLayouts, file)
+			} else {
+				textLayouts = append(textLayouts, file)
+			}
+		}
+	}
+
+	tmpl := &entry{}
+
+	if set.exists(name + subjectSuffix) {
+		subject, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(set.funcs)).
+			ParseFS(set.fsys, append(textLayouts, name+subjectSuffix)...)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.subject = subject.Lookup(path.Base(name + subjectSuffix))
+	}
+
+	if set.exists(name + textSuffix) {
+		text, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(set.funcs)).
+			ParseFS(set.fsys, append(textLayouts, name+textSuffix)...)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.text = text
+	}
+
+	if set.exists(name + htmlSuffix) {
+		html, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(set.funcs)).
+			ParseFS(set.fsys, append(htmlLayouts, name+htmlSuffix)...)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.html = html
+	}
+
+	if tmpl.text == nil && tmpl.html == nil {
+		return nil, fmt.Errorf("m-mail: template %q has no body", name)
+	}
+
+	return tmpl, nil
+}
+
+//Check whether a file exists in the file system of the set
+func (set *Set) exists(name string) bool {
+	_, err := fs.Stat(set.fsys, name)
+	return !errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,83 @@
+package template
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRender(t *testing.T) {
+	fsys := fstest.MapFS{
+		"welcome.subject.tmpl":  {Data: []byte("Welcome\n{{.Name}}\n")},
+		"welcome.text.tmpl":     {Data: []byte(`{{template "greeting" .}}, thanks for joining.`)},
+		"welcome.html.tmpl":     {Data: []byte(`{{define "content"}}<p>Hi {{.Name}}</p>{{end}}{{template "layout" .}}`)},
+		"layout/greeting.tmpl":  {Data: []byte(`{{define "greeting"}}Hi {{upper .Name}}{{end}}`)},
+		"layout/main.html.tmpl": {Data: []byte(`{{define "layout"}}<body>{{template "content" .}}</body>{{end}}`)},
+	}
+	set := New(fsys, SetLayouts("layout/*"), SetFuncs(FuncMap{"upper": strings.ToUpper}))
+
+	msg, err := set.Render("welcome", map[string]string{"Name": "<Bob>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(msg.GetEmailBytes("bob@example.com"))
+
+	if !strings.Contains(out, "Subject: Welcome <Bob>\r\n") {
+		t.Errorf("unexpected subject in:\n%s", out)
+	}
+	// The HTML body is an alternative of the plain text one, and the only one
+	// escaping the data.
+	text := strings.Index(out, "Hi <BOB>, thanks for joining.")
+	html := strings.Index(out, "<body><p>Hi &lt;Bob&gt;</p></body>")
+	if text == -1 || html == -1 || text > html {
+		t.Errorf("unexpected bodies in:\n%s", out)
+	}
+}
+
+func TestRenderSubjectLayout(t *testing.T) {
+	set := New(fstest.MapFS{
+		"news.subject.tmpl":     {Data: []byte(`{{template "brand"}}: {{.}}`)},
+		"news.text.tmpl":        {Data: []byte("Hi")},
+		"layout/brand.tmpl":     {Data: []byte(`{{define "brand"}}Example{{end}}`)},
+		"layout/page.html.tmpl": {Data: []byte(`{{define "brand"}}<b>Example</b>{{end}}`)},
+	}, SetLayouts("layout/*"))
+
+	msg, err := set.Render("news", "October")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := msg.GetSubject(); subject != "Example: October" {
+		t.Errorf("subject = %q", subject)
+	}
+}
+
+func TestRenderHTMLOnly(t *testing.T) {
+	set := New(fstest.MapFS{"reset.html.tmpl": {Data: []byte(`<a href="{{.}}">Reset</a>`)}})
+
+	msg, err := set.Render("reset", "javascript:alert(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(msg.GetEmailBytes("bob@example.com"))
+	if strings.Contains(out, "text/plain") || !strings.Contains(out, "Content-Type: text/html") {
+		t.Errorf("unexpected parts in:\n%s", out)
+	}
+	if strings.Contains(out, "javascript:") {
+		t.Errorf("unsafe url not escaped in:\n%s", out)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	set := New(fstest.MapFS{
+		"empty.subject.tmpl":   {Data: []byte("No body")},
+		"invalid.text.tmpl":    {Data: []byte("Hi {{.Name")},
+		"failing.text.tmpl":    {Data: []byte(`Hi {{template "missing"}}`)},
+		"failing.subject.tmpl": {Data: []byte("Hi")},
+	})
+
+	for _, name := range []string{"missing", "empty", "invalid", "failing"} {
+		if _, err := set.Render(name, nil); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
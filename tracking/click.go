@@ -0,0 +1,113 @@
+package tracking
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ishail/m-mail/message"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// noTrackAttr is the attribute of the links that must not be rewritten.
+const noTrackAttr = "data-notrack"
+
+// TrackClicks is a message setting to rewrite the links of the HTML parts of
+// the email so clicks are recorded by the click handler of the tracker.
+func TrackClicks(tracker *Tracker) message.MessageSetting {
+	return func(msg *message.Message) {
+		// The Message-Id is assigned now so that rendering does not modify
+		// the message.
+		msg.MessageID()
+		msg.AddHTMLFilter(func(body, recipient string) string {
+			return tracker.RewriteLinks(body, msg.MessageID(), recipient)
+		})
+	}
+}
+
+// ClickURL returns the signed url redirecting to target and recording a click
+// of the recipient of a message.
+func (tracker *Tracker) ClickURL(messageID, recipient, target string) string {
+	params := url.Values{}
+	params.Set("m", messageID)
+	params.Set("r", recipient)
+	params.Set("u", target)
+	return tracker.signedURL("click", params, "m", "r", "u")
+}
+
+// RewriteLinks replaces the href of the http and https links of an HTML body
+// with their click url. Links with a data-notrack attribute are left as is,
+// except that the attribute is removed.
+func (tracker *Tracker) RewriteLinks(body, messageID, recipient string) string {
+	var buff bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return body
+			}
+			return buff.String()
+		}
+
+		raw := tokenizer.Raw()
+		if tokenType != html.StartTagToken {
+			buff.Write(raw)
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.DataAtom != atom.A || !tracker.rewriteLink(&token, messageID, recipient) {
+			buff.Write(raw)
+			continue
+		}
+		buff.WriteString(token.String())
+	}
+}
+
+//Rewrite the href of a link token and report whether it was modified
+func (tracker *Tracker) rewriteLink(token *html.Token, messageID, recipient string) bool {
+	for index, attr := range token.Attr {
+		if attr.Key == noTrackAttr {
+			token.Attr = append(token.Attr[:index], token.Attr[index+1:]...)
+			return true
+		}
+	}
+
+	for index, attr := range token.Attr {
+		if attr.Key != "href" {
+			continue
+		}
+		target := strings.TrimSpace(attr.Val)
+		lower := strings.ToLower(target)
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			return false
+		}
+		token.Attr[index].Val = tracker.ClickURL(messageID, recipient, target)
+		return true
+	}
+
+	return false
+}
+
+// ClickHandler returns a handler recording the clicks in store and redirecting
+// to the original urls. Requests with an invalid signature are rejected. The
+// redirection happens even if the event cannot be recorded.
+func (tracker *Tracker) ClickHandler(store EventStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tracker.verifyRequest(r, "click", "m", "r", "u") {
+			http.NotFound(w, r)
+			return
+		}
+
+		event := tracker.newEvent(Click, r)
+		event.URL = r.URL.Query().Get("u")
+		store.Record(event)
+
+		http.Redirect(w, r, event.URL, http.StatusFound)
+	})
+}
@@ -0,0 +1,101 @@
+package tracking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+)
+
+func TestNewTrackerRequiresKey(t *testing.T) {
+	if _, err := NewTracker("https://example.com/t", nil); err == nil {
+		t.Fatal("NewTracker accepted an empty key")
+	}
+
+	tracker := &Tracker{BaseURL: "https://example.com/t"}
+	target := tracker.ClickURL("<id@example.com>", "bob@example.com", "https://example.com")
+	rec := httptest.NewRecorder()
+	tracker.ClickHandler(&memoryStore{}).ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("a url signed with an empty key was accepted: %d", rec.Code)
+	}
+}
+
+func TestClickHandler(t *testing.T) {
+	tracker := newTestTracker(t)
+	store := &memoryStore{}
+	target := tracker.ClickURL("<id@example.com>", "bob@example.com", "https://example.com/a?b=1")
+
+	rec := httptest.NewRecorder()
+	tracker.ClickHandler(store).ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/a?b=1" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(store.events) != 1 || store.events[0].Recipient != "bob@example.com" ||
+		store.events[0].MessageID != "<id@example.com>" {
+		t.Fatalf("unexpected events %+v", store.events)
+	}
+
+	forged := strings.Replace(target, "example.com%2Fa", "evil.com%2Fa", 1)
+	rec = httptest.NewRecorder()
+	tracker.ClickHandler(store).ServeHTTP(rec, httptest.NewRequest("GET", forged, nil))
+	if rec.Code != http.StatusNotFound || len(store.events) != 1 {
+		t.Fatalf("a forged url was accepted: %d", rec.Code)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	tracker := newTestTracker(t)
+	body := `<a href="https://example.com">a</a><a data-notrack href="https://example.org">b</a><a href="mailto:bob@example.com">c</a>`
+
+	out := tracker.RewriteLinks(body, "<id@example.com>", "bob@example.com")
+	if !strings.Contains(out, `href="https://example.com/t/click?`) {
+		t.Errorf("link not rewritten: %s", out)
+	}
+	if !strings.Contains(out, `<a href="https://example.org">b</a>`) {
+		t.Errorf("data-notrack link rewritten: %s", out)
+	}
+	if !strings.Contains(out, `href="mailto:bob@example.com"`) {
+		t.Errorf("mailto link rewritten: %s", out)
+	}
+}
+
+func TestTrackClicksMessageID(t *testing.T) {
+	tracker := newTestTracker(t)
+	msg := message.NewMessage("Hello", `<a href="https://example.com">a</a>`, "html", TrackClicks(tracker))
+	msg.SetHeader("From", "alice@example.com")
+
+	id := msg.GetHeader("Message-Id")
+	if len(id) != 1 {
+		t.Fatalf("TrackClicks did not assign a Message-Id: %v", id)
+	}
+
+	// Concurrent renderings only read the message and share its Message-Id.
+	var wg sync.WaitGroup
+	outputs := make([]string, 4)
+	for index := range outputs {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			outputs[index] = string(msg.GetEmailBytes("bob@example.com"))
+		}(index)
+	}
+	wg.Wait()
+
+	pattern := regexp.MustCompile(`m=3D([^&"]+)`)
+	for _, out := range outputs {
+		out = strings.ReplaceAll(out, "=\r\n", "")
+		match := pattern.FindStringSubmatch(out)
+		if match == nil {
+			t.Fatalf("no click url in %s", out)
+		}
+		if got, _ := url.QueryUnescape(match[1]); got != id[0] {
+			t.Fatalf("click url has Message-Id %q, want %q", got, id[0])
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package tracking
+
+import (
+	"time"
+)
+
+// A Tracker creates the signed tracking urls of the emails and serves the
+// handlers they point to.
+type Tracker struct {
+	// BaseURL is the url under which the handlers of the Tracker are served,
+	// for example "https://mail.example.com/t". The click handler must be
+	// served at BaseURL + "/click", the open handler at BaseURL + "/open" and
+	// the unsubscribe handler at BaseURL + "/unsubscribe".
+	BaseURL string
+	// Key is the secret used to sign the tracking urls. The handlers reject
+	// every request when it is empty.
+	Key []byte
+	// TrustProxyHeaders defines whether the IP address of events is read from
+	// the X-Forwarded-For header. It should only be set when the handlers are
+	// served behind a proxy setting this header.
+	TrustProxyHeaders bool
+}
+
+// EventType is the type of a tracking Event.
+type EventType string
+
+const (
+	// Click is the type of the events recorded when a recipient follows a link.
+	Click EventType = "click"
+	// Open is the type of the events recorded when a recipient displays the
+	// tracking pixel of an email.
+	Open EventType = "open"
+)
+
+// An Event is recorded by the handlers of a Tracker when a recipient interacts
+// with an email.
+type Event struct {
+	Type      EventType
+	MessageID string
+	Recipient string
+	// URL is the original url of the link for Click events.
+	URL       string
+	Time      time.Time
+	UserAgent string
+	IP        string
+}
+
+// EventStore is the interface that wraps the Record method.
+// Record stores an event recorded by the handlers of a Tracker.
+type EventStore interface {
+	Record(event *Event) error
+}
@@ -0,0 +1,51 @@
+package tracking
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// pixel is a transparent 1x1 GIF image.
+var pixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// TrackOpens is a message setting to add a tracking pixel to the HTML parts of
+// the email so opens are recorded by the open handler of the tracker. Each
+// recipient gets its own pixel url.
+func TrackOpens(tracker *Tracker) message.MessageSetting {
+	return func(msg *message.Message) {
+		// The Message-Id is assigned now so that rendering does not modify
+		// the message.
+		msg.MessageID()
+		msg.AddTrackingUrlFunc(func(recipient string) string {
+			return tracker.OpenURL(msg.MessageID(), recipient)
+		})
+	}
+}
+
+// OpenURL returns the signed url of the tracking pixel of the recipient of a
+// message.
+func (tracker *Tracker) OpenURL(messageID, recipient string) string {
+	params := url.Values{}
+	params.Set("m", messageID)
+	params.Set("r", recipient)
+	return tracker.signedURL("open", params, "m", "r")
+}
+
+// OpenHandler returns a handler serving the tracking pixel and recording the
+// opens in store. The pixel is served even if the signature is invalid or the
+// event cannot be recorded, but only valid requests are recorded.
+func (tracker *Tracker) OpenHandler(store EventStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracker.verifyRequest(r, "open", "m", "r") {
+			store.Record(tracker.newEvent(Open, r))
+		}
+
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Expires", "0")
+		w.Write(pixel)
+	})
+}
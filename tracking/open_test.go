@@ -0,0 +1,80 @@
+package tracking
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+)
+
+// memoryStore is an EventStore keeping the events in memory.
+type memoryStore struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (store *memoryStore) Record(event *Event) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.events = append(store.events, event)
+	return nil
+}
+
+func newTestTracker(t *testing.T) *Tracker {
+	tracker, err := NewTracker("https://example.com/t/", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tracker
+}
+
+func TestOpenHandler(t *testing.T) {
+	tracker := newTestTracker(t)
+	store := &memoryStore{}
+	target := tracker.OpenURL("<id@example.com>", "bob@example.com")
+
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("User-Agent", "Mail/1.0")
+	rec := httptest.NewRecorder()
+	tracker.OpenHandler(store).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/gif" ||
+		!bytes.Equal(rec.Body.Bytes(), pixel) {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if len(store.events) != 1 {
+		t.Fatalf("unexpected events %+v", store.events)
+	}
+	event := store.events[0]
+	if event.Type != Open || event.MessageID != "<id@example.com>" ||
+		event.Recipient != "bob@example.com" || event.UserAgent != "Mail/1.0" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	// The pixel is served to forged urls, which are not recorded.
+	forged := strings.Replace(target, "bob%40example.com", "carol%40example.com", 1)
+	rec = httptest.NewRecorder()
+	tracker.OpenHandler(store).ServeHTTP(rec, httptest.NewRequest("GET", forged, nil))
+	if rec.Code != http.StatusOK || len(store.events) != 1 {
+		t.Fatalf("a forged url was recorded: %d %+v", rec.Code, store.events)
+	}
+}
+
+func TestTrackOpens(t *testing.T) {
+	tracker := newTestTracker(t)
+	msg := message.NewMessage("Hello", "<p>Hi</p>", "html", TrackOpens(tracker))
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com", "carol@example.com")
+
+	for _, recipient := range []string{"bob@example.com", "carol@example.com"} {
+		out := strings.ReplaceAll(string(msg.GetEmailBytes(recipient)), "=\r\n", "")
+		out = strings.ReplaceAll(out, "=3D", "=")
+		want := strings.ReplaceAll(tracker.OpenURL(msg.MessageID(), recipient), "&", "&amp;")
+		if !strings.Contains(out, want) {
+			t.Errorf("no pixel for %s in:\n%s", recipient, out)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+	Package tracking rewrites emails to track how recipients interact with them
+	and provides the HTTP handlers recording these interactions.
+*/
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errNoKey is returned by NewTracker when the signing key is empty.
+var errNoKey = errors.New("m-mail: the tracking key must not be empty")
+
+// NewTracker returns a new Tracker serving its handlers under baseURL and
+// signing its urls with key. An error is returned if key is empty, since
+// anyone could then forge tracking urls.
+func NewTracker(baseURL string, key []byte) (*Tracker, error) {
+	if len(key) == 0 {
+		return nil, errNoKey
+	}
+
+	return &Tracker{
+		BaseURL: baseURL,
+		Key:     key,
+	}, nil
+}
+
+//Return the signature of the given values
+func (tracker *Tracker) sign(values ...string) string {
+	mac := hmac.New(sha256.New, tracker.Key)
+	for _, val := range values {
+		mac.Write([]byte(val))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//Check the signature of the given values
+func (tracker *Tracker) verify(signature string, values ...string) bool {
+	if len(tracker.Key) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(tracker.sign(values...)))
+}
+
+//Return the signed url of a handler for the given query parameters
+func (tracker *Tracker) signedURL(path string, params url.Values, keys ...string) string {
+	values := make([]string, len(keys))
+	for index, key := range keys {
+		values[index] = params.Get(key)
+	}
+	params.Set("s", tracker.sign(append([]string{path}, values...)...))
+
+	return strings.TrimRight(tracker.BaseURL, "/") + "/" + path + "?" + params.Encode()
+}
+
+//Check the signature of the query parameters of a request to a handler
+func (tracker *Tracker) verifyRequest(r *http.Request, path string, keys ...string) bool {
+	query := r.URL.Query()
+	values := make([]string, len(keys))
+	for index, key := range keys {
+		values[index] = query.Get(key)
+	}
+	return tracker.verify(query.Get("s"), append([]string{path}, values...)...)
+}
+
+//Return a new event of the given type for a request
+func (tracker *Tracker) newEvent(eventType EventType, r *http.Request) *Event {
+	query := r.URL.Query()
+	return &Event{
+		Type:      eventType,
+		MessageID: query.Get("m"),
+		Recipient: query.Get("r"),
+		Time:      time.Now(),
+		UserAgent: r.UserAgent(),
+		IP:        tracker.remoteIP(r),
+	}
+}
+
+//Return the IP address of the client of a request
+func (tracker *Tracker) remoteIP(r *http.Request) string {
+	if tracker.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
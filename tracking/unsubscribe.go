@@ -0,0 +1,130 @@
+package tracking
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ishail/m-mail/common"
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/suppression"
+)
+
+var errInvalidToken = errors.New("m-mail: invalid unsubscribe token")
+
+// unsubscribePage is served on GET requests so that link scanners and
+// prefetching do not unsubscribe recipients.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head><body>
+<form method="post"><input type="hidden" name="List-Unsubscribe" value="One-Click">
+<p>Unsubscribe {{.}}?</p><button type="submit">Unsubscribe</button></form>
+</body></html>`))
+
+// ListUnsubscribe is a message setting to add the List-Unsubscribe and
+// List-Unsubscribe-Post headers defined in RFC 2369 and RFC 8058 to the email.
+// Each recipient gets its own signed unsubscribe urls for the given list. When
+// mailbox is not empty, a mailto url to that address is added, with the token
+// in the subject. The category of the email is set to list, so that the
+// opt-outs recorded by UnsubscribeHandler suppress its recipients.
+func ListUnsubscribe(tracker *Tracker, list, mailbox string) message.MessageSetting {
+	return func(msg *message.Message) {
+		message.SetCategory(list)(msg)
+		msg.AddHeaderFunc(func(recipient string) common.Header {
+			urls := []string{"<" + tracker.UnsubscribeURL(recipient, list) + ">"}
+			if mailbox != "" {
+				urls = append(urls, "<"+tracker.UnsubscribeMailto(mailbox, recipient, list)+">")
+			}
+
+			return common.Header{
+				"List-Unsubscribe":      urls,
+				"List-Unsubscribe-Post": {"List-Unsubscribe=One-Click"},
+			}
+		})
+	}
+}
+
+// UnsubscribeToken returns the signed token identifying the subscription of a
+// recipient to a list.
+func (tracker *Tracker) UnsubscribeToken(recipient, list string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(recipient)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(list)) + "." +
+		tracker.sign("unsubscribe", recipient, list)
+}
+
+// ParseUnsubscribeToken verifies a token returned by UnsubscribeToken and
+// returns the recipient and the list it identifies.
+func (tracker *Tracker) ParseUnsubscribeToken(token string) (string, string, error) {
+	fields := strings.Split(token, ".")
+	if len(fields) != 3 {
+		return "", "", errInvalidToken
+	}
+
+	recipient, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil {
+		return "", "", errInvalidToken
+	}
+	list, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", errInvalidToken
+	}
+	if !tracker.verify(fields[2], "unsubscribe", string(recipient), string(list)) {
+		return "", "", errInvalidToken
+	}
+
+	return string(recipient), string(list), nil
+}
+
+// UnsubscribeURL returns the url of the unsubscribe handler for the recipient
+// of a list.
+func (tracker *Tracker) UnsubscribeURL(recipient, list string) string {
+	params := url.Values{}
+	params.Set("t", tracker.UnsubscribeToken(recipient, list))
+	return strings.TrimRight(tracker.BaseURL, "/") + "/unsubscribe?" + params.Encode()
+}
+
+// UnsubscribeMailto returns a mailto url to mailbox whose subject contains the
+// unsubscribe token of the recipient of a list. The emails received by mailbox
+// can be verified with ParseUnsubscribeToken.
+func (tracker *Tracker) UnsubscribeMailto(mailbox, recipient, list string) string {
+	return "mailto:" + mailbox + "?subject=" +
+		url.PathEscape("unsubscribe "+tracker.UnsubscribeToken(recipient, list))
+}
+
+// UnsubscribeHandler returns a handler recording in store the opt-outs sent as
+// defined in RFC 8058, with a POST request. GET requests are answered with a
+// confirmation form. The opt-outs are recorded with the list as category.
+func (tracker *Tracker) UnsubscribeHandler(store suppression.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recipient, list, err := tracker.ParseUnsubscribeToken(r.URL.Query().Get("t"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			unsubscribePage.Execute(w, recipient)
+		case http.MethodPost:
+			err := store.Add(&suppression.Entry{
+				Address:  recipient,
+				Category: list,
+				Reason:   suppression.Unsubscribe,
+				Time:     time.Now(),
+			})
+			if err != nil {
+				http.Error(w, "unable to unsubscribe", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("You have been unsubscribed.\n"))
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
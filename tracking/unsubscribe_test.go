@@ -0,0 +1,141 @@
+package tracking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ishail/m-mail/message"
+	"github.com/ishail/m-mail/sender"
+	"github.com/ishail/m-mail/smtptest"
+	"github.com/ishail/m-mail/suppression"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	tracker := newTestTracker(t)
+	token := tracker.UnsubscribeToken("bob@example.com", "news")
+
+	recipient, list, err := tracker.ParseUnsubscribeToken(token)
+	if err != nil || recipient != "bob@example.com" || list != "news" {
+		t.Fatalf("ParseUnsubscribeToken() = %q, %q, %v", recipient, list, err)
+	}
+
+	other := tracker.UnsubscribeToken("bob@example.com", "offers")
+	fields, otherFields := strings.Split(token, "."), strings.Split(other, ".")
+	for _, forged := range []string{
+		"", "a.b", token + "x",
+		fields[0] + "." + otherFields[1] + "." + fields[2],
+	} {
+		if _, _, err := tracker.ParseUnsubscribeToken(forged); err == nil {
+			t.Errorf("forged token %q accepted", forged)
+		}
+	}
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	tracker := newTestTracker(t)
+	store := suppression.NewMemoryStore()
+	handler := tracker.UnsubscribeHandler(store)
+	target := tracker.UnsubscribeURL("bob@example.com", "news")
+
+	// Link scanners following the url do not unsubscribe the recipient.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<form method="post">`) {
+		t.Fatalf("unexpected response %d:\n%s", rec.Code, rec.Body.String())
+	}
+	if len(store.Entries()) != 0 {
+		t.Fatal("a GET request unsubscribed the recipient")
+	}
+
+	req := httptest.NewRequest("POST", target, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d", rec.Code)
+	}
+	entry, err := store.Lookup("bob@example.com", "news")
+	if err != nil || entry == nil || entry.Reason != suppression.Unsubscribe {
+		t.Fatalf("Lookup() = %+v, %v", entry, err)
+	}
+	if entry, _ := store.Lookup("bob@example.com", "offers"); entry != nil {
+		t.Fatal("the recipient was unsubscribed from another list")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", strings.Replace(target, "t=", "t=x", 1), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("an invalid token was accepted: %d", rec.Code)
+	}
+}
+
+func TestListUnsubscribe(t *testing.T) {
+	tracker := newTestTracker(t)
+	msg := message.NewMessage("Hello", "Hi", "text/plain",
+		ListUnsubscribe(tracker, "news", "unsubscribe@example.com"))
+	msg.SetHeader("From", "alice@example.com")
+
+	out := strings.ReplaceAll(string(msg.GetEmailBytes("bob@example.com")), "\r\n ", " ")
+	unsubscribeURL := tracker.UnsubscribeURL("bob@example.com", "news")
+	mailto := tracker.UnsubscribeMailto("unsubscribe@example.com", "bob@example.com", "news")
+	if !strings.Contains(out, "List-Unsubscribe: <"+unsubscribeURL+">, <"+mailto+">\r\n") {
+		t.Errorf("unexpected List-Unsubscribe header in:\n%s", out)
+	}
+	if msg.GetCategory() != "news" {
+		t.Errorf("category = %q", msg.GetCategory())
+	}
+	if !strings.Contains(out, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n") {
+		t.Errorf("no List-Unsubscribe-Post header in:\n%s", out)
+	}
+
+	subject, err := url.PathUnescape(strings.TrimPrefix(mailto, "mailto:unsubscribe@example.com?subject="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recipient, list, err := tracker.ParseUnsubscribeToken(strings.TrimPrefix(subject, "unsubscribe ")); err != nil ||
+		recipient != "bob@example.com" || list != "news" {
+		t.Errorf("invalid mailto token: %q, %q, %v", recipient, list, err)
+	}
+}
+
+func TestUnsubscribeSuppressesSend(t *testing.T) {
+	tracker := newTestTracker(t)
+	store := suppression.NewMemoryStore()
+
+	req := httptest.NewRequest("POST", tracker.UnsubscribeURL("bob@example.com", "news"),
+		strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	tracker.UnsubscribeHandler(store).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d", rec.Code)
+	}
+
+	srv := smtptest.NewServer()
+	defer srv.Close()
+	dialer := srv.Dialer()
+	dialer.Suppressions = store
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := message.NewMessage("Hello", "Hi", "text/plain", ListUnsubscribe(tracker, "news", ""))
+	msg.SetHeader("From", "alice@example.com")
+	msg.SetHeader("To", "bob@example.com", "carol@example.com")
+	result, err := conn.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suppressed := result.Addresses(sender.Suppressed); len(suppressed) != 1 || suppressed[0] != "bob@example.com" {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if transactions := srv.Transactions(); len(transactions) != 1 || len(transactions[0].To) != 1 ||
+		transactions[0].To[0] != "carol@example.com" {
+		t.Fatalf("unexpected transactions %v", transactions)
+	}
+}